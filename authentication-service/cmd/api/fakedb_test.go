@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver answering the queries of the data package
+// with rows picked by a test, and recording the statements it runs
+type fakeDB struct {
+	mu     sync.Mutex
+	answer func(query string, args []driver.Value) ([][]driver.Value, error)
+	execs  []fakeExec
+}
+
+// fakeExec is a statement run on a fakeDB
+type fakeExec struct {
+	query string
+	args  []driver.Value
+}
+
+// openFakeDB returns a database whose queries are answered by answer
+func openFakeDB(t *testing.T, answer func(query string, args []driver.Value) ([][]driver.Value, error)) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fake := &fakeDB{answer: answer}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	return db, fake
+}
+
+func (f *fakeDB) Connect(ctx context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                            { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.db, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no transactions") }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.execs = append(s.db.execs, fakeExec{query: s.query, args: args})
+
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if s.db.answer == nil {
+		return nil, errors.New("unexpected query")
+	}
+
+	rows, err := s.db.answer(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"column"}
+	}
+
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
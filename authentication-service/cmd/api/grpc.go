@@ -2,12 +2,17 @@ package main
 
 import (
 	"authentication-service/auths"
+	"authentication-service/data"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type AuthServer struct {
@@ -16,39 +21,266 @@ type AuthServer struct {
 
 func (a *AuthServer) Authenticate(ctx context.Context, req *auths.AuthRequest) (*auths.AuthResponse, error) {
 	input := req.GetAuthEntry()
+	d := app.grpcDevice(ctx)
+	requestID := requestIDFromMetadata(ctx)
+
+	email := normalizeEmail(input.Email)
+
+	err := app.checkLockout(ctx, email, d.IPAddress)
+	if err != nil {
+		var locked *lockedError
+		if !errors.As(err, &locked) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		loginsTotal.WithLabelValues("grpc", "locked").Inc()
+		res := &auths.AuthResponse{Result: err.Error()}
+		return res, status.Error(codes.PermissionDenied, err.Error())
+	}
 
 	// validate the user against the database
-	user, err := app.Models.User.GetByEmail(input.Email)
+	user, err := app.Models.User.GetByEmail(ctx, email)
 	if err != nil {
+		loginsTotal.WithLabelValues("grpc", "failure").Inc()
+		app.loginFailed(ctx, email, d.IPAddress, requestID)
 		res := &auths.AuthResponse{Result: "invalid credentials"}
-		return res, err
+		return res, status.Error(codes.Unauthenticated, "invalid credentials")
 	}
 
 	valid, err := user.PasswordMatches(input.Password)
 	if err != nil || !valid {
+		loginsTotal.WithLabelValues("grpc", "failure").Inc()
+		app.loginFailed(ctx, email, d.IPAddress, requestID)
 		res := &auths.AuthResponse{Result: "invalid credentials"}
+		return res, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+
+	err = checkActive(user)
+	if err != nil {
+		loginsTotal.WithLabelValues("grpc", "inactive").Inc()
+		res := &auths.AuthResponse{Result: err.Error()}
+		return res, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	// only logins that go through clear the failures of the account
+	app.loginSucceeded(ctx, email)
+
+	token, err := app.startSession(ctx, user, d)
+	if err != nil {
+		res := &auths.AuthResponse{Result: "failed issuing token"}
 		return res, err
 	}
 
+	loginsTotal.WithLabelValues("grpc", "success").Inc()
+
 	// log authentication
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	err = app.logRequest(ctx, "authentication", fmt.Sprintf("%s logged in", user.Email), requestID)
 	if err != nil {
 		res := &auths.AuthResponse{Result: "faild log request"}
 		return res, err
 	}
 
 	// return response
-	res := &auths.AuthResponse{Result: "Authenticated via gRPC"}
+	res := &auths.AuthResponse{
+		Result:           "Authenticated via gRPC",
+		AccessToken:      token.AccessToken,
+		TokenType:        token.TokenType,
+		ExpiresAt:        token.ExpiresAt.Unix(),
+		RefreshToken:     token.RefreshToken,
+		RefreshExpiresAt: token.RefreshExpiresAt.Unix(),
+	}
+	return res, nil
+}
+
+// ValidateToken lets other services verify an access token issued by this
+// service, and that its session wasn't revoked
+func (a *AuthServer) ValidateToken(ctx context.Context, req *auths.TokenRequest) (*auths.TokenResponse, error) {
+	claims, err := app.validateAccessToken(ctx, req.GetToken())
+	if err != nil {
+		res := &auths.TokenResponse{Valid: false, Error: err.Error()}
+		return res, nil
+	}
+
+	res := &auths.TokenResponse{
+		Valid:     true,
+		UserId:    int64(claims.UserID),
+		Email:     claims.Email,
+		Role:      claims.Role,
+		ExpiresAt: claims.ExpiresAt.Unix(),
+	}
 	return res, nil
 }
 
+// CheckRevoked lets other services that validate access tokens themselves check
+// that the session of a token wasn't revoked by a logout
+func (a *AuthServer) CheckRevoked(ctx context.Context, req *auths.TokenRequest) (*auths.RevocationResponse, error) {
+	claims, err := app.Tokens.Validate(req.GetToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	revoked, err := app.sessionRevoked(ctx, claims)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &auths.RevocationResponse{Revoked: revoked, SessionId: claims.SessionID}, nil
+}
+
+// authorizeAdmin checks that the caller sent the access token of an admin
+func (a *AuthServer) authorizeAdmin(ctx context.Context) error {
+	_, err := app.authorizeAdmin(ctx, authorizationFromMetadata(ctx))
+	switch {
+	case errors.Is(err, errAdminOnly):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case err != nil:
+		return status.Error(codes.Unauthenticated, err.Error())
+	default:
+		return nil
+	}
+}
+
+// userResponse sends back the user affected by a user method, or its error
+func userResponse(user *data.User, err error) (*auths.UserResponse, error) {
+	if err != nil {
+		return nil, status.Error(userErrorCode(err), err.Error())
+	}
+
+	return &auths.UserResponse{User: userMessage(user)}, nil
+}
+
+func userMessage(user *data.User) *auths.User {
+	return &auths.User{
+		Id:        int64(user.ID),
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Active:    int32(user.Active),
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt.Unix(),
+		UpdatedAt: user.UpdatedAt.Unix(),
+	}
+}
+
+func userInput(input *auths.UserInput) UserInput {
+	in := UserInput{
+		Email:     input.GetEmail(),
+		FirstName: input.GetFirstName(),
+		LastName:  input.GetLastName(),
+		Password:  input.GetPassword(),
+		Role:      input.GetRole(),
+	}
+
+	if input.GetActive() != nil {
+		active := int(input.GetActive().GetValue())
+		in.Active = &active
+	}
+
+	return in
+}
+
+// CreateUser registers a new user
+func (a *AuthServer) CreateUser(ctx context.Context, req *auths.CreateUserRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.createUser(ctx, userInput(req.GetUser())))
+}
+
+// ListUsers returns every user, sorted by last name
+func (a *AuthServer) ListUsers(ctx context.Context, req *auths.ListUsersRequest) (*auths.ListUsersResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := app.Models.User.GetAll(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	res := &auths.ListUsersResponse{}
+	for _, user := range users {
+		res.Users = append(res.Users, userMessage(user))
+	}
+
+	return res, nil
+}
+
+// GetUser returns one user
+func (a *AuthServer) GetUser(ctx context.Context, req *auths.UserRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.getUser(ctx, int(req.GetId())))
+}
+
+// UpdateUser replaces the email, names, and optionally the active flag and
+// role of a user
+func (a *AuthServer) UpdateUser(ctx context.Context, req *auths.UpdateUserRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.updateUser(ctx, int(req.GetId()), userInput(req.GetUser())))
+}
+
+// DeleteUser deletes a user and returns it
+func (a *AuthServer) DeleteUser(ctx context.Context, req *auths.UserRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.deleteUser(ctx, int(req.GetId())))
+}
+
+// SetPassword sets the password of a user
+func (a *AuthServer) SetPassword(ctx context.Context, req *auths.PasswordRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.setPassword(ctx, int(req.GetId()), req.GetPassword()))
+}
+
+// UnlockUser ends the lockout of a user after too many failed logins
+func (a *AuthServer) UnlockUser(ctx context.Context, req *auths.UserRequest) (*auths.UserResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return userResponse(app.unlockUser(ctx, int(req.GetId())))
+}
+
+// UnlockAddress ends the lockout of a client address after too many failed logins
+func (a *AuthServer) UnlockAddress(ctx context.Context, req *auths.AddressRequest) (*auths.AddressResponse, error) {
+	err := a.authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := app.unlockAddress(ctx, req.GetAddress())
+	if err != nil {
+		return nil, status.Error(userErrorCode(err), err.Error())
+	}
+
+	return &auths.AddressResponse{Address: address}, nil
+}
+
 func (app *Config) gRPCListen() {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", gRpcPort))
 	if err != nil {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), metricsInterceptor))
 
 	auths.RegisterAuthServiceServer(s, &AuthServer{})
 
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"authentication-service/data"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// authData is the data sent back to a user that authenticated successfully
+type authData struct {
+	User  *data.User `json:"user"`
+	Token Token      `json:"token"`
+}
+
 func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		Name     string `json:"name"`
@@ -21,21 +33,60 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	email := normalizeEmail(requestPayload.Email)
+	d := app.httpDevice(r)
+	requestID := r.Header.Get(requestIDHeader)
+
+	err = app.checkLockout(r.Context(), email, d.IPAddress)
+	if err != nil {
+		var locked *lockedError
+		if !errors.As(err, &locked) {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		loginsTotal.WithLabelValues("http", "locked").Inc()
+		w.Header().Set("Retry-After", strconv.Itoa(int(locked.retryAfter().Seconds())))
+		app.errorJSON(w, err, http.StatusTooManyRequests)
+		return
+	}
+
 	// validate the user against the database
-	user, err := app.Models.User.GetByEmail(requestPayload.Email)
+	user, err := app.Models.User.GetByEmail(r.Context(), email)
 	if err != nil {
+		loginsTotal.WithLabelValues("http", "failure").Inc()
+		app.loginFailed(r.Context(), email, d.IPAddress, requestID)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
 		return
 	}
 
 	valid, err := user.PasswordMatches(requestPayload.Password)
 	if err != nil || !valid {
+		loginsTotal.WithLabelValues("http", "failure").Inc()
+		app.loginFailed(r.Context(), email, d.IPAddress, requestID)
 		app.errorJSON(w, errors.New("invalid credentials"), http.StatusUnauthorized)
 		return
 	}
 
+	err = checkActive(user)
+	if err != nil {
+		loginsTotal.WithLabelValues("http", "inactive").Inc()
+		app.errorJSON(w, err, http.StatusForbidden)
+		return
+	}
+
+	// only logins that go through clear the failures of the account
+	app.loginSucceeded(r.Context(), email)
+
+	token, err := app.startSession(r.Context(), user, d)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	loginsTotal.WithLabelValues("http", "success").Inc()
+
 	// log authentication
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	err = app.logRequest(r.Context(), "authentication", fmt.Sprintf("%s logged in", user.Email), requestID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -44,13 +95,236 @@ func (app *Config) Authenticate(w http.ResponseWriter, r *http.Request) {
 	payload := jsonResponse{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		Data: authData{
+			User:  user,
+			Token: token,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// sessionErrorStatus is the response status code of an error of the session
+// endpoints: 401 for refresh tokens that can't be used, 403 for inactive
+// users, 500 otherwise
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+		return http.StatusUnauthorized
+	}
+	if errors.Is(err, errInactiveUser) {
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token of the same session; the old refresh token can't be used again.
+func (app *Config) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, token, err := app.refreshSession(r.Context(), requestPayload.RefreshToken, app.httpDevice(r))
+	if err != nil {
+		app.errorJSON(w, err, sessionErrorStatus(err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Refreshed token of user %s", user.Email),
+		Data: authData{
+			User:  user,
+			Token: token,
+		},
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Logout revokes the session of a refresh token, and with it the access tokens
+// issued for the session.
+func (app *Config) Logout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.endSession(r.Context(), requestPayload.RefreshToken)
+	if err != nil {
+		app.errorJSON(w, err, sessionErrorStatus(err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Logged out",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// LogoutAll revokes every session of the user the bearer token was issued to.
+func (app *Config) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	claims, err := app.authorize(r.Context(), r.Header.Get("Authorization"))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.Models.RefreshToken.RevokeAllForUser(r.Context(), claims.UserID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Logged out every session of user %s", claims.Email),
 	}
 
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-func (app *Config) logRequest(name, data string) error {
+// UnlockUser ends the lockout of a user after too many failed logins
+func (app *Config) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userID(r)
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	user, err := app.unlockUser(r.Context(), id)
+	app.writeUser(w, http.StatusOK, fmt.Sprintf("Unlocked user %d", id), user, err)
+}
+
+// UnlockAddress ends the lockout of a client address after too many failed logins
+func (app *Config) UnlockAddress(w http.ResponseWriter, r *http.Request) {
+	address, err := app.unlockAddress(r.Context(), chi.URLParam(r, "address"))
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Unlocked address %s", address),
+		Data:    map[string]string{"address": address},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Register signs up a new user, which stays inactive until it follows the link
+// mailed to verify its email.
+func (app *Config) Register(w http.ResponseWriter, r *http.Request) {
+	var input UserInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.register(r.Context(), input, r.Header.Get(requestIDHeader))
+	app.writeUser(w, http.StatusAccepted, fmt.Sprintf("Registered user %s, follow the link mailed to verify the email", input.Email), user, err)
+}
+
+// VerifyEmail activates the user of an email verification token.
+func (app *Config) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		VerificationToken string `json:"verification_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.verifyEmail(r.Context(), requestPayload.VerificationToken)
+	if errors.Is(err, errInvalidVerificationToken) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	app.writeUser(w, http.StatusAccepted, fmt.Sprintf("Verified the email of user %s", user.Email), user, nil)
+}
+
+// ForgotPassword mails a password reset link to the user with the email. The
+// response is the same whether there is such a user or not.
+func (app *Config) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	app.forgotPassword(r.Context(), requestPayload.Email, r.Header.Get(requestIDHeader))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If the email belongs to a user, a password reset link was sent to it",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResetPassword sets a new password with a reset token, and logs out every
+// session of its user.
+func (app *Config) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ResetToken string `json:"reset_token"`
+		Password   string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.resetPassword(r.Context(), requestPayload.ResetToken, requestPayload.Password)
+	if errors.Is(err, errInvalidResetToken) {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password reset",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// logRequest sends a log entry to the logger service, along with the correlation
+// ID of the request that caused it
+func (app *Config) logRequest(ctx context.Context, name, data, requestID string) error {
 	var entry struct {
 		Name string `json:"name"`
 		Data string `json:"data"`
@@ -62,16 +336,146 @@ func (app *Config) logRequest(name, data string) error {
 	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 	logServiceURL := "http://logger-service/log"
 
-	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", logServiceURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{}
-	_, err = client.Do(request)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(requestIDHeader, requestID)
+
+	client := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 
 	return nil
 }
+
+// userID returns the id URL parameter of a request
+func userID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return 0, invalidInput("invalid user id %s", chi.URLParam(r, "id"))
+	}
+
+	return id, nil
+}
+
+// writeUser sends back the user affected by a user method, or its error
+func (app *Config) writeUser(w http.ResponseWriter, status int, message string, user *data.User, err error) {
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    user,
+	}
+
+	app.writeJSON(w, status, payload)
+}
+
+// CreateUser registers a new user
+func (app *Config) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var input UserInput
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.createUser(r.Context(), input)
+	app.writeUser(w, http.StatusCreated, fmt.Sprintf("Created user %s", input.Email), user, err)
+}
+
+// ListUsers returns every user, sorted by last name
+func (app *Config) ListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.Models.User.GetAll(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d users", len(users)),
+		Data:    users,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// GetUser returns one user
+func (app *Config) GetUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userID(r)
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	user, err := app.getUser(r.Context(), id)
+	app.writeUser(w, http.StatusOK, fmt.Sprintf("User %d", id), user, err)
+}
+
+// UpdateUser replaces the email, names, and optionally the active flag and
+// role of a user
+func (app *Config) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userID(r)
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	var input UserInput
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.updateUser(r.Context(), id, input)
+	app.writeUser(w, http.StatusOK, fmt.Sprintf("Updated user %d", id), user, err)
+}
+
+// DeleteUser deletes a user and returns it
+func (app *Config) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := userID(r)
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	user, err := app.deleteUser(r.Context(), id)
+	app.writeUser(w, http.StatusOK, fmt.Sprintf("Deleted user %d", id), user, err)
+}
+
+// SetPassword sets the password of a user
+func (app *Config) SetPassword(w http.ResponseWriter, r *http.Request) {
+	id, err := userID(r)
+	if err != nil {
+		app.errorJSON(w, err, userErrorStatus(err))
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.setPassword(r.Context(), id, requestPayload.Password)
+	app.writeUser(w, http.StatusOK, fmt.Sprintf("Changed the password of user %d", id), user, err)
+}
@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// The correlation ID of a request is received in this header or gRPC metadata key.
+const (
+	requestIDHeader   = "X-Request-ID"
+	requestIDMetadata = "x-request-id"
 )
 
+// authorizationMetadata carries the access token of gRPC callers, like the
+// Authorization header of HTTP requests.
+const authorizationMetadata = "authorization"
+
 type jsonResponse struct {
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
@@ -71,3 +84,33 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// requestIDFromMetadata returns the correlation ID sent by a gRPC caller
+func requestIDFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(requestIDMetadata)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// authorizationFromMetadata returns the authorization sent by a gRPC caller
+func authorizationFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(authorizationMetadata)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMaxFailures        = 5
+	defaultMaxAddressFailures = 20
+	defaultFailureWindow      = 15 * time.Minute
+	defaultLockoutDuration    = 15 * time.Minute
+	defaultLoginDelay         = 250 * time.Millisecond
+
+	// maxLoginDelay caps the delay of the answers to failed logins
+	maxLoginDelay = 8 * time.Second
+
+	// lockedRoutingKey is the routing key of the event published on lockouts
+	lockedRoutingKey = "auth.LOCKED"
+)
+
+// lockedError is returned for the logins of a locked out account or address
+type lockedError struct {
+	until time.Time
+}
+
+func (e *lockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, try again in %s", e.retryAfter())
+}
+
+// retryAfter is the time left until the lockout ends
+func (e *lockedError) retryAfter() time.Duration {
+	return time.Until(e.until).Round(time.Second)
+}
+
+// Lockout protects the logins against brute force: the answers to failed
+// logins are delayed longer with every failure, and accounts and addresses
+// are locked out once they failed too many times in a row.
+type Lockout struct {
+	maxFailures        int
+	maxAddressFailures int
+	window             time.Duration
+	duration           time.Duration
+	delay              time.Duration
+}
+
+// newLockout configures the lockouts from the environment:
+//
+//	LOGIN_MAX_FAILURES          failed logins of an account before it is locked out
+//	LOGIN_MAX_ADDRESS_FAILURES  failed logins from an address before it is locked out
+//	LOGIN_FAILURE_WINDOW        time after which the failed logins are forgotten, like 15m
+//	LOGIN_LOCKOUT_DURATION      time the lockouts last, like 15m
+//	LOGIN_DELAY                 delay of the answer to a first failed login, doubled with every failure (0 disables it)
+func newLockout() (*Lockout, error) {
+	l := &Lockout{
+		maxFailures:        defaultMaxFailures,
+		maxAddressFailures: defaultMaxAddressFailures,
+		window:             defaultFailureWindow,
+		duration:           defaultLockoutDuration,
+		delay:              defaultLoginDelay,
+	}
+
+	for name, value := range map[string]*int{
+		"LOGIN_MAX_FAILURES":         &l.maxFailures,
+		"LOGIN_MAX_ADDRESS_FAILURES": &l.maxAddressFailures,
+	} {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s: %s", name, s)
+			}
+			*value = n
+		}
+	}
+
+	for name, value := range map[string]*time.Duration{
+		"LOGIN_FAILURE_WINDOW":   &l.window,
+		"LOGIN_LOCKOUT_DURATION": &l.duration,
+		"LOGIN_DELAY":            &l.delay,
+	} {
+		if s := os.Getenv(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*value = d
+		}
+	}
+
+	return l, nil
+}
+
+// lockKey is an account or address the failed logins are counted for
+type lockKey struct {
+	scope string
+	key   string
+}
+
+// keys returns the account of email and the address the failed logins of a
+// login are counted for. Logins without a client address are only counted for
+// the account.
+func (l *Lockout) keys(email, address string) []lockKey {
+	keys := []lockKey{{scope: data.ScopeAccount, key: accountKey(email)}}
+	if address != "" {
+		keys = append(keys, lockKey{scope: data.ScopeAddress, key: addressKey(address)})
+	}
+
+	return keys
+}
+
+// limit is the number of failed logins after which a key of scope is locked out
+func (l *Lockout) limit(scope string) int {
+	if scope == data.ScopeAddress {
+		return l.maxAddressFailures
+	}
+
+	return l.maxFailures
+}
+
+// delayAfter is the delay of the answer to the nth failed login in a row
+func (l *Lockout) delayAfter(n int) time.Duration {
+	delay := l.delay
+	for i := 1; i < n && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+
+	return delay
+}
+
+// accountKey is the key of the failed logins of the account of email. Emails
+// that belong to no account are counted too, so lockouts don't tell whether
+// an email is in use.
+func accountKey(email string) string {
+	return normalizeEmail(email)
+}
+
+// addressKey is the key of the failed logins from address, written the same
+// way whatever form it was forwarded in
+func addressKey(address string) string {
+	if ip := net.ParseIP(address); ip != nil {
+		return ip.String()
+	}
+
+	return address
+}
+
+// checkLockout returns a *lockedError when the account of email or the address
+// is locked out. It is checked before the password, so a locked out account
+// can't be brute forced.
+func (app *Config) checkLockout(ctx context.Context, email, address string) error {
+	for _, k := range app.Lockout.keys(email, address) {
+		failure, err := app.Models.LoginFailure.Get(ctx, k.scope, k.key)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if failure.LockedUntil != nil && time.Now().Before(*failure.LockedUntil) {
+			return &lockedError{until: *failure.LockedUntil}
+		}
+	}
+
+	return nil
+}
+
+// loginFailed counts a failed login of the account of email from the address,
+// locks them out once they failed too many times, and waits for the delay of
+// the failure before the answer is sent.
+func (app *Config) loginFailed(ctx context.Context, email, address, requestID string) {
+	failures := 0
+
+	for _, k := range app.Lockout.keys(email, address) {
+		failure, err := app.Models.LoginFailure.Record(ctx, k.scope, k.key, app.Lockout.window)
+		if err != nil {
+			log.Println("Error recording failed login:", err)
+			continue
+		}
+
+		if failure.Failures > failures {
+			failures = failure.Failures
+		}
+
+		if failure.Failures < app.Lockout.limit(k.scope) {
+			continue
+		}
+
+		until := time.Now().Add(app.Lockout.duration)
+
+		locked, err := app.Models.LoginFailure.Lock(ctx, k.scope, k.key, until)
+		if err != nil {
+			log.Println("Error locking out after failed logins:", err)
+			continue
+		}
+
+		if locked {
+			app.lockedOut(ctx, k, failure.Failures, until, requestID)
+		}
+	}
+
+	delay := time.NewTimer(app.Lockout.delayAfter(failures))
+	defer delay.Stop()
+
+	select {
+	case <-delay.C:
+	case <-ctx.Done():
+	}
+}
+
+// lockedOut reports a lockout with an auth.LOCKED event, recorded by the
+// logger service through the listener
+func (app *Config) lockedOut(ctx context.Context, k lockKey, failures int, until time.Time, requestID string) {
+	lockoutsTotal.WithLabelValues(k.scope).Inc()
+
+	entry := struct {
+		Name string `json:"name"`
+		Data string `json:"data"`
+	}{
+		Name: "authentication",
+		Data: fmt.Sprintf("%s %s locked out until %s after %d failed logins", k.scope, k.key, until.UTC().Format(time.RFC3339), failures),
+	}
+
+	log.Println(entry.Data)
+
+	inBackground(ctx, "publishing lockout", func(ctx context.Context) error {
+		return app.Events.publish(ctx, lockedRoutingKey, entry, requestID)
+	})
+}
+
+// loginSucceeded forgets the failed logins of the account of email. Those of
+// the address are kept, as it may be trying other accounts.
+func (app *Config) loginSucceeded(ctx context.Context, email string) {
+	err := app.Models.LoginFailure.Clear(ctx, data.ScopeAccount, accountKey(email))
+	if err != nil {
+		log.Println("Error clearing failed logins:", err)
+	}
+}
+
+// unlockUser forgets the failed logins of the user with id, which ends its lockout
+func (app *Config) unlockUser(ctx context.Context, id int) (*data.User, error) {
+	user, err := app.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.LoginFailure.Clear(ctx, data.ScopeAccount, accountKey(user.Email))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// unlockAddress forgets the failed logins from the client address, which ends
+// its lockout, and returns the address as its failures are counted
+func (app *Config) unlockAddress(ctx context.Context, address string) (string, error) {
+	if net.ParseIP(strings.TrimSpace(address)) == nil {
+		return "", invalidInput("%q is not an IP address", address)
+	}
+
+	key := addressKey(strings.TrimSpace(address))
+
+	err := app.Models.LoginFailure.Clear(ctx, data.ScopeAddress, key)
+	if err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLockoutDelayAfter(t *testing.T) {
+	l := &Lockout{delay: 250 * time.Millisecond}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 250 * time.Millisecond},
+		{failures: 1, want: 250 * time.Millisecond},
+		{failures: 2, want: 500 * time.Millisecond},
+		{failures: 3, want: time.Second},
+		{failures: 6, want: 8 * time.Second},
+		{failures: 7, want: maxLoginDelay},
+		{failures: 1000, want: maxLoginDelay},
+	}
+
+	for _, tt := range tests {
+		got := l.delayAfter(tt.failures)
+		if got != tt.want {
+			t.Errorf("delayAfter(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	disabled := &Lockout{}
+	if got := disabled.delayAfter(10); got != 0 {
+		t.Errorf("delayAfter(10) without delay = %s, want 0", got)
+	}
+}
+
+func TestLockoutLimit(t *testing.T) {
+	l := &Lockout{maxFailures: 5, maxAddressFailures: 20}
+
+	tests := []struct {
+		scope string
+		want  int
+	}{
+		{scope: data.ScopeAccount, want: 5},
+		{scope: data.ScopeAddress, want: 20},
+	}
+
+	for _, tt := range tests {
+		got := l.limit(tt.scope)
+		if got != tt.want {
+			t.Errorf("limit(%s) = %d, want %d", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutKeys(t *testing.T) {
+	l := &Lockout{}
+
+	tests := []struct {
+		name    string
+		email   string
+		address string
+		want    []lockKey
+	}{
+		{
+			name:    "account and address",
+			email:   " User@Example.com ",
+			address: "203.0.113.7",
+			want: []lockKey{
+				{scope: data.ScopeAccount, key: "user@example.com"},
+				{scope: data.ScopeAddress, key: "203.0.113.7"},
+			},
+		},
+		{
+			name:    "address in another form",
+			email:   "user@example.com",
+			address: "::0001",
+			want: []lockKey{
+				{scope: data.ScopeAccount, key: "user@example.com"},
+				{scope: data.ScopeAddress, key: "::1"},
+			},
+		},
+		{
+			name:  "no address",
+			email: "user@example.com",
+			want:  []lockKey{{scope: data.ScopeAccount, key: "user@example.com"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := l.keys(tt.email, tt.address)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("keys(%q, %q) = %v, want %v", tt.email, tt.address, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLockout(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    *Lockout
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			want: &Lockout{
+				maxFailures:        defaultMaxFailures,
+				maxAddressFailures: defaultMaxAddressFailures,
+				window:             defaultFailureWindow,
+				duration:           defaultLockoutDuration,
+				delay:              defaultLoginDelay,
+			},
+		},
+		{
+			name: "from the environment",
+			env: map[string]string{
+				"LOGIN_MAX_FAILURES":         "3",
+				"LOGIN_MAX_ADDRESS_FAILURES": "50",
+				"LOGIN_FAILURE_WINDOW":       "1h",
+				"LOGIN_LOCKOUT_DURATION":     "30m",
+				"LOGIN_DELAY":                "0",
+			},
+			want: &Lockout{
+				maxFailures:        3,
+				maxAddressFailures: 50,
+				window:             time.Hour,
+				duration:           30 * time.Minute,
+				delay:              0,
+			},
+		},
+		{name: "zero failures", env: map[string]string{"LOGIN_MAX_FAILURES": "0"}, wantErr: true},
+		{name: "failures not a number", env: map[string]string{"LOGIN_MAX_ADDRESS_FAILURES": "many"}, wantErr: true},
+		{name: "invalid duration", env: map[string]string{"LOGIN_LOCKOUT_DURATION": "15"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"LOGIN_MAX_FAILURES", "LOGIN_MAX_ADDRESS_FAILURES", "LOGIN_FAILURE_WINDOW", "LOGIN_LOCKOUT_DURATION", "LOGIN_DELAY"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := newLockout()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlockAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{name: "IPv4 address", address: "203.0.113.7", want: "203.0.113.7"},
+		{name: "IPv6 address in another form", address: " ::0001 ", want: "::1"},
+		{name: "not an address", address: "broker-service", wantErr: true},
+		{name: "no address", address: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := openFakeDB(t, nil)
+			app := Config{Models: data.New(db), Lockout: &Lockout{}}
+
+			got, err := app.unlockAddress(context.Background(), tt.address)
+
+			if tt.wantErr {
+				var invalid *invalidInputError
+				if !errors.As(err, &invalid) {
+					t.Errorf("err = %v, want an invalid input error", err)
+				}
+				if len(fake.execs) != 0 {
+					t.Errorf("cleared the failures of an invalid address: %v", fake.execs)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("unlockAddress(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+
+			want := []driver.Value{data.ScopeAddress, tt.want}
+			if len(fake.execs) != 1 || !reflect.DeepEqual(fake.execs[0].args, want) {
+				t.Errorf("execs = %v, want one with args %v", fake.execs, want)
+			}
+		})
+	}
+}
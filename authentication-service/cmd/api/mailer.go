@@ -0,0 +1,90 @@
+package main
+
+import (
+	"authentication-service/mails"
+	"context"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const defaultMailerAddress = "mailer-service:50001"
+
+// Mailer sends the mails of the service, rendered from the templates of the
+// mailer service, through its gRPC API.
+type Mailer struct {
+	client mails.MailServiceClient
+}
+
+// newMailer connects to the mailer service at MAILER_GRPC_ADDRESS. The
+// connection is made lazily, the mailer doesn't have to be up yet.
+func newMailer() (*Mailer, error) {
+	address := os.Getenv("MAILER_GRPC_ADDRESS")
+	if address == "" {
+		address = defaultMailerAddress
+	}
+
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mailer{client: mails.NewMailServiceClient(conn)}, nil
+}
+
+// send mails the template of the mailer service, rendered with data, to to
+func (m *Mailer) send(ctx context.Context, requestID, to, subject, template string, data map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadata, requestID)
+
+	_, err := m.client.SendMail(ctx, &mails.MailRequest{
+		MailEntry: &mails.Mail{
+			To:       to,
+			Subject:  subject,
+			Template: template,
+			Data:     data,
+		},
+	})
+
+	return err
+}
+
+// tokenLink returns the link to the page at base, with token as its token
+// query parameter
+func tokenLink(base, token string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// inBackground runs fn once the request of ctx is over, keeping only its
+// trace, and logs its error
+func inBackground(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	ctx = trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+
+	go func() {
+		err := fn(ctx)
+		if err != nil {
+			log.Printf("Error %s: %v", name, err)
+		}
+	}()
+}
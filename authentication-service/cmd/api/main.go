@@ -2,12 +2,12 @@ package main
 
 import (
 	"authentication-service/data"
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
-	"net/rpc"
 	"os"
 	"time"
 
@@ -17,36 +17,87 @@ import (
 )
 
 const (
-	webPort = "80"
-	rpcPort = "5001"
+	webPort  = "80"
+	rpcPort  = "5001"
 	gRpcPort = "50001"
 )
 
 var app Config
 
 type Config struct {
-	DB     *sql.DB
-	Models data.Models
+	DB            *sql.DB
+	Models        data.Models
+	Tokens        *Tokens
+	Mailer        *Mailer
+	Resets        *Resets
+	Verifications *Verifications
+	Lockout       *Lockout
+	Proxies       *TrustedProxies
+	Events        *Events
 }
 
 func main() {
+	// set up tracing
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// connect to DB
 	conn := connectToDB()
 	if conn == nil {
 		log.Panic("Can't connect to Postgres!")
 	}
 
-	// set up config
-	app = Config{
-		DB:     conn,
-		Models: data.New(conn),
+	// set up the access token signer
+	tokens, err := newTokens()
+	if err != nil {
+		log.Panic(err)
 	}
 
-	// Register the RPC Server
-	err := rpc.Register(new(RPCServer))
+	// set up the mails sent through the mailer service
+	mailer, err := newMailer()
 	if err != nil {
 		log.Panic(err)
 	}
+
+	resets, err := newResets()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	verifications, err := newVerifications()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// set up the brute force protection of the logins
+	lockout, err := newLockout()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// set up the callers that pass on the address of their clients
+	proxies, err := newTrustedProxies()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// set up config
+	app = Config{
+		DB:            conn,
+		Models:        data.New(conn),
+		Tokens:        tokens,
+		Mailer:        mailer,
+		Resets:        resets,
+		Verifications: verifications,
+		Lockout:       lockout,
+		Proxies:       proxies,
+		Events:        newEvents(),
+	}
+
+	// serve the RPC Server
 	go app.rpcListen()
 
 	go app.gRPCListen()
@@ -77,7 +128,7 @@ func (app *Config) rpcListen() error {
 		if err != nil {
 			continue
 		}
-		go rpc.ServeConn(rpcConn)
+		go serveRPCConn(rpcConn)
 	}
 }
 
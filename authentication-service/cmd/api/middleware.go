@@ -0,0 +1,59 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin lets through the requests with a valid bearer token issued to
+// an admin.
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := app.authorizeAdmin(r.Context(), r.Header.Get("Authorization"))
+		if err != nil {
+			status := http.StatusUnauthorized
+			if errors.Is(err, errAdminOnly) {
+				status = http.StatusForbidden
+			}
+			app.errorJSON(w, err, status)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorize validates the bearer token of an Authorization header or metadata
+// value, and its session, and returns its claims.
+func (app *Config) authorize(ctx context.Context, header string) (*Claims, error) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, errors.New("missing or invalid authorization header")
+	}
+
+	claims, err := app.validateAccessToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	return claims, nil
+}
+
+// authorizeAdmin is authorize for the tokens issued to admins. Others get
+// errAdminOnly.
+func (app *Config) authorizeAdmin(ctx context.Context, header string) (*Claims, error) {
+	claims, err := app.authorize(ctx, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Role != data.RoleAdmin {
+		return nil, errAdminOnly
+	}
+
+	return claims, nil
+}
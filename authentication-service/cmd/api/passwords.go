@@ -0,0 +1,133 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultResetTTL = time.Hour
+	defaultResetURL = "http://localhost/reset-password"
+
+	// resetTemplate is the template of the mailer the reset links are sent with
+	resetTemplate = "password-reset"
+)
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// Resets configures the password reset links.
+type Resets struct {
+	url string
+	ttl time.Duration
+}
+
+// newResets configures password resets from the environment:
+//
+//	PASSWORD_RESET_URL  page the reset links point to, with the token as the token query parameter
+//	PASSWORD_RESET_TTL  lifetime of the reset tokens, like 1h
+func newResets() (*Resets, error) {
+	r := &Resets{
+		url: defaultResetURL,
+		ttl: defaultResetTTL,
+	}
+
+	if u := os.Getenv("PASSWORD_RESET_URL"); u != "" {
+		r.url = u
+	}
+
+	if ttl := os.Getenv("PASSWORD_RESET_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PASSWORD_RESET_TTL: %w", err)
+		}
+		r.ttl = d
+	}
+
+	return r, nil
+}
+
+// forgotPassword starts a password reset in the background, so the response,
+// and the time it takes, is the same whether the email belongs to a user or not.
+func (app *Config) forgotPassword(ctx context.Context, email, requestID string) {
+	inBackground(ctx, "sending password reset", func(ctx context.Context) error {
+		return app.sendPasswordReset(ctx, email, requestID)
+	})
+}
+
+// sendPasswordReset creates a reset token for the user with email, if any, and
+// mails its link to the user
+func (app *Config) sendPasswordReset(ctx context.Context, email, requestID string) error {
+	ctx, span := tracer.Start(ctx, "sendPasswordReset")
+	defer span.End()
+
+	email = normalizeEmail(email)
+
+	user, err := app.Models.User.GetByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token := newOpaqueToken()
+
+	_, err = app.Models.PasswordReset.Insert(ctx, data.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(app.Resets.ttl),
+	})
+	if err != nil {
+		return err
+	}
+
+	link, err := tokenLink(app.Resets.url, token)
+	if err != nil {
+		return err
+	}
+
+	return app.Mailer.send(ctx, requestID, user.Email, "Reset your password", resetTemplate, map[string]string{
+		"name":    user.FirstName,
+		"link":    link,
+		"expires": app.Resets.ttl.String(),
+	})
+}
+
+// resetPassword sets the password of the user of a reset token, which can't be
+// used again, and revokes the sessions of the user
+func (app *Config) resetPassword(ctx context.Context, token, password string) error {
+	err := validatePassword(password)
+	if err != nil {
+		return err
+	}
+
+	userID, err := app.Models.PasswordReset.Use(ctx, hashToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := app.getUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = user.ResetPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	err = app.Models.PasswordReset.UseAllForUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return app.Models.RefreshToken.RevokeAllForUser(ctx, userID)
+}
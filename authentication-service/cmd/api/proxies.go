@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTrustedProxies are the services that call the authentication
+	// service on behalf of their clients
+	defaultTrustedProxies = "broker-service,listener-service"
+	// proxyLookupInterval is how long the addresses of a proxy host are cached
+	proxyLookupInterval = time.Minute
+)
+
+// TrustedProxies are the callers whose X-Forwarded-For header, or
+// x-forwarded-for gRPC metadata, is believed. The address of any other caller
+// is its own, so a client can't pick the address its failed logins count for.
+type TrustedProxies struct {
+	networks []*net.IPNet
+	hosts    []string
+
+	mu      sync.Mutex
+	addrs   map[string][]net.IP
+	checked time.Time
+}
+
+// newTrustedProxies configures the trusted proxies from TRUSTED_PROXIES, a
+// comma separated list of host names, addresses and CIDR ranges, by default
+// the broker and the listener of the compose file
+func newTrustedProxies() (*TrustedProxies, error) {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if value == "" {
+		value = defaultTrustedProxies
+	}
+
+	p := &TrustedProxies{addrs: make(map[string][]net.IP)}
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+
+		switch {
+		case field == "":
+			continue
+		case strings.Contains(field, "/"):
+			_, network, err := net.ParseCIDR(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES range %s: %w", field, err)
+			}
+			p.networks = append(p.networks, network)
+		case net.ParseIP(field) != nil:
+			ip := net.ParseIP(field)
+			p.networks = append(p.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+		default:
+			p.hosts = append(p.hosts, field)
+		}
+	}
+
+	return p, nil
+}
+
+// trusted reports whether the caller at address is a trusted proxy. Host
+// names are looked up again every proxyLookupInterval, as containers get new
+// addresses when they are restarted.
+func (p *TrustedProxies) trusted(address string) bool {
+	ip := net.ParseIP(address)
+	if p == nil || ip == nil {
+		return false
+	}
+
+	for _, network := range p.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	for _, addr := range p.hostAddrs() {
+		if addr.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hostAddrs returns the addresses of the trusted host names. Hosts that can't
+// be looked up keep their last known addresses.
+func (p *TrustedProxies) hostAddrs() []net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.hosts) > 0 && time.Since(p.checked) > proxyLookupInterval {
+		for _, host := range p.hosts {
+			addrs, err := net.LookupIP(host)
+			if err == nil {
+				p.addrs[host] = addrs
+			}
+		}
+		p.checked = time.Now()
+	}
+
+	var addrs []net.IP
+	for _, host := range p.hosts {
+		addrs = append(addrs, p.addrs[host]...)
+	}
+
+	return addrs
+}
+
+// clientAddress returns the address of the client of a caller at remote,
+// which is forwardedFor when the caller is a trusted proxy
+func (p *TrustedProxies) clientAddress(remote, forwardedFor string) string {
+	if forwardedFor != "" && p.trusted(remote) {
+		ip, _, _ := strings.Cut(forwardedFor, ",")
+		return strings.TrimSpace(ip)
+	}
+
+	return remote
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestTrustedProxiesClientAddress(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.5,localhost")
+
+	proxies, err := newTrustedProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		proxies      *TrustedProxies
+		remote       string
+		forwardedFor string
+		want         string
+	}{
+		{name: "proxy in a trusted range", proxies: proxies, remote: "10.1.2.3", forwardedFor: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted proxy address", proxies: proxies, remote: "192.168.1.5", forwardedFor: "203.0.113.7", want: "203.0.113.7"},
+		{name: "trusted proxy host", proxies: proxies, remote: "127.0.0.1", forwardedFor: "203.0.113.7", want: "203.0.113.7"},
+		{name: "first of several forwarded addresses", proxies: proxies, remote: "10.1.2.3", forwardedFor: " 203.0.113.7 , 10.1.2.4", want: "203.0.113.7"},
+		{name: "trusted proxy without forwarded address", proxies: proxies, remote: "10.1.2.3", forwardedFor: "", want: "10.1.2.3"},
+		{name: "untrusted caller", proxies: proxies, remote: "192.168.1.6", forwardedFor: "203.0.113.7", want: "192.168.1.6"},
+		{name: "caller without an address", proxies: proxies, remote: "", forwardedFor: "203.0.113.7", want: ""},
+		{name: "no trusted proxies", proxies: nil, remote: "10.1.2.3", forwardedFor: "203.0.113.7", want: "10.1.2.3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.proxies.clientAddress(tt.remote, tt.forwardedFor)
+			if got != tt.want {
+				t.Errorf("clientAddress(%q, %q) = %q, want %q", tt.remote, tt.forwardedFor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTrustedProxiesInvalidRange(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "broker-service,10.0.0.0/33")
+
+	_, err := newTrustedProxies()
+	if err == nil {
+		t.Error("invalid range accepted")
+	}
+}
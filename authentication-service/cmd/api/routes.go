@@ -6,24 +6,58 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
-
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{"https://*", "http://*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders: []string{"Link"},
+		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
-		MaxAge: 300,
+		MaxAge:           300,
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	mux.Use(metrics)
+
 	mux.Post("/authenticate", app.Authenticate)
-	return mux
-}
\ No newline at end of file
+	mux.Post("/register", app.Register)
+	mux.Post("/verify-email", app.VerifyEmail)
+	mux.Post("/token/refresh", app.RefreshToken)
+	mux.Post("/logout", app.Logout)
+	mux.Post("/logout/all", app.LogoutAll)
+	mux.Post("/password/forgot", app.ForgotPassword)
+	mux.Post("/password/reset", app.ResetPassword)
+
+	// user management, for admins only
+	mux.Route("/users", func(mux chi.Router) {
+		mux.Use(app.requireAdmin)
+
+		mux.Post("/", app.CreateUser)
+		mux.Get("/", app.ListUsers)
+		mux.Get("/{id}", app.GetUser)
+		mux.Put("/{id}", app.UpdateUser)
+		mux.Delete("/{id}", app.DeleteUser)
+		mux.Post("/{id}/password", app.SetPassword)
+		mux.Post("/{id}/unlock", app.UnlockUser)
+	})
+
+	// lockouts of client addresses, for admins only
+	mux.With(app.requireAdmin).Post("/addresses/{address}/unlock", app.UnlockAddress)
+
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return otelhttp.NewHandler(mux, serviceName, otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// spanName names the span of a request after its method and path
+func spanName(operation string, r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
@@ -2,19 +2,48 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/rpc"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RPCServer is the type for our RPC Server. Methods that take this as a receiver are available
 // over RPC, as long as they are exported.
-type RPCServer struct{}
+type RPCServer struct {
+	// remote is the address of the caller of the connection
+	remote string
+}
+
+// serveRPCConn serves the RPC Server on conn, which is told the address of its caller
+func serveRPCConn(conn net.Conn) {
+	remote, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	server := rpc.NewServer()
+	err := server.Register(&RPCServer{remote: remote})
+	if err != nil {
+		log.Println("error registering the RPC server", err)
+		conn.Close()
+		return
+	}
+
+	server.ServeConn(conn)
+}
 
 // RPCPayload is the type for data we receive from RPC
 type RPCPayload struct {
-	Name     string
-	Email    string
-	Password string
+	RequestID    string
+	TraceContext map[string]string
+	Name         string
+	Email        string
+	Password     string
+	// ClientAddress is the address of the client the broker was called from,
+	// only believed from a trusted proxy
+	ClientAddress string
 }
 
 type RPCResponse struct {
@@ -23,23 +52,60 @@ type RPCResponse struct {
 	Data    any
 }
 
-// LogInfo writes our payload to mongo
-func (r *RPCServer) AuthenticateViaRPC(payload RPCPayload, resp *[]byte) error {
+// AuthenticateViaRPC validates the credentials in payload and sends back the user and an access token
+func (r *RPCServer) AuthenticateViaRPC(payload RPCPayload, resp *[]byte) (err error) {
+	defer observeRPC("RPCServer.AuthenticateViaRPC", time.Now(), &err)
+
+	ctx, span := tracer.Start(contextFromCarrier(payload.TraceContext), "RPCServer.AuthenticateViaRPC", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	email := normalizeEmail(payload.Email)
+	address := app.Proxies.clientAddress(r.remote, payload.ClientAddress)
+
+	err = app.checkLockout(ctx, email, address)
+	if err != nil {
+		log.Println("login refused", err)
+		loginsTotal.WithLabelValues("rpc", "locked").Inc()
+		return err
+	}
+
 	// validate the user against the database
-	user, err := app.Models.User.GetByEmail(payload.Email)
+	user, err := app.Models.User.GetByEmail(ctx, email)
 	if err != nil {
 		log.Println("invalid credentials", err)
-		return err
+		loginsTotal.WithLabelValues("rpc", "failure").Inc()
+		app.loginFailed(ctx, email, address, payload.RequestID)
+		return errors.New("invalid credentials")
 	}
 
 	valid, err := user.PasswordMatches(payload.Password)
 	if err != nil || !valid {
 		log.Println("invalid credentials", err)
+		loginsTotal.WithLabelValues("rpc", "failure").Inc()
+		app.loginFailed(ctx, email, address, payload.RequestID)
+		return errors.New("invalid credentials")
+	}
+
+	err = checkActive(user)
+	if err != nil {
+		log.Println("inactive user", user.Email)
+		loginsTotal.WithLabelValues("rpc", "inactive").Inc()
 		return err
 	}
 
+	// only logins that go through clear the failures of the account
+	app.loginSucceeded(ctx, email)
+
+	token, err := app.startSession(ctx, user, device{IPAddress: address})
+	if err != nil {
+		log.Println("error issuing token", err)
+		return err
+	}
+
+	loginsTotal.WithLabelValues("rpc", "success").Inc()
+
 	// log authentication
-	err = app.logRequest("authentication", fmt.Sprintf("%s logged in", user.Email))
+	err = app.logRequest(ctx, "authentication", fmt.Sprintf("%s logged in", user.Email), payload.RequestID)
 	if err != nil {
 		log.Println("error logging authentication", err)
 		return err
@@ -49,7 +115,10 @@ func (r *RPCServer) AuthenticateViaRPC(payload RPCPayload, resp *[]byte) error {
 	*resp, _ = json.Marshal(RPCResponse{
 		Error:   false,
 		Message: "Authenticated via RPC in user " + user.Email,
-		Data:    user,
+		Data: authData{
+			User:  user,
+			Token: token,
+		},
 	})
 
 	return nil
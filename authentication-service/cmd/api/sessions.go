@@ -0,0 +1,231 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token already used, the session was revoked")
+	errSessionRevoked      = errors.New("session revoked")
+)
+
+// device describes where a session was started from
+type device struct {
+	UserAgent string
+	IPAddress string
+}
+
+// httpDevice returns the device of an HTTP request. The X-Forwarded-For
+// header has the address of the client of a trusted proxy.
+func (app *Config) httpDevice(r *http.Request) device {
+	remote, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	return device{
+		UserAgent: r.UserAgent(),
+		IPAddress: app.Proxies.clientAddress(remote, r.Header.Get("X-Forwarded-For")),
+	}
+}
+
+// grpcDevice returns the device of a gRPC call. Like X-Forwarded-For over
+// HTTP, the x-forwarded-for metadata has the address of the client of a
+// trusted proxy.
+func (app *Config) grpcDevice(ctx context.Context) device {
+	var d device
+	var forwardedFor, remote string
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			d.UserAgent = values[0]
+		}
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			forwardedFor = values[0]
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		remote, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+
+	d.IPAddress = app.Proxies.clientAddress(remote, forwardedFor)
+
+	return d
+}
+
+// startSession starts a new session for user and returns its first access and
+// refresh tokens
+func (app *Config) startSession(ctx context.Context, user *data.User, d device) (Token, error) {
+	return app.issueSession(ctx, user, newSessionID(), d)
+}
+
+// issueSession issues an access token and a new refresh token of the session
+func (app *Config) issueSession(ctx context.Context, user *data.User, sessionID string, d device) (Token, error) {
+	token, err := app.Tokens.Issue(user, sessionID)
+	if err != nil {
+		return Token{}, err
+	}
+
+	refreshToken := newOpaqueToken()
+	expiresAt := time.Now().Add(app.Tokens.refreshTTL)
+
+	_, err = app.Models.RefreshToken.Insert(ctx, data.RefreshToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(refreshToken),
+		FamilyID:  sessionID,
+		UserAgent: d.UserAgent,
+		IPAddress: d.IPAddress,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	token.RefreshToken = refreshToken
+	token.RefreshExpiresAt = expiresAt
+
+	return token, nil
+}
+
+// refreshSession rotates a refresh token: it is revoked and replaced by a new
+// one of the same session, issued with a new access token. A refresh token
+// that was already rotated means it leaked, so its whole session is revoked,
+// like the sessions of inactive users.
+func (app *Config) refreshSession(ctx context.Context, refreshToken string, d device) (*data.User, Token, error) {
+	current, err := app.Models.RefreshToken.GetByHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, Token{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, Token{}, err
+	}
+
+	rotated := false
+	if current.RevokedAt == nil {
+		rotated, err = app.Models.RefreshToken.Revoke(ctx, current.ID)
+		if err != nil {
+			return nil, Token{}, err
+		}
+	}
+
+	if !rotated {
+		log.Println("Refresh token reused, revoking session", current.FamilyID)
+		err = app.Models.RefreshToken.RevokeFamily(ctx, current.FamilyID)
+		if err != nil {
+			return nil, Token{}, err
+		}
+		return nil, Token{}, errRefreshTokenReused
+	}
+
+	if time.Now().After(current.ExpiresAt) {
+		return nil, Token{}, errInvalidRefreshToken
+	}
+
+	user, err := app.Models.User.GetOne(ctx, current.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, Token{}, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, Token{}, err
+	}
+
+	// deactivated users are logged out of the sessions they still have
+	err = checkActive(user)
+	if err != nil {
+		revokeErr := app.Models.RefreshToken.RevokeFamily(ctx, current.FamilyID)
+		if revokeErr != nil {
+			return nil, Token{}, revokeErr
+		}
+		return nil, Token{}, err
+	}
+
+	token, err := app.issueSession(ctx, user, current.FamilyID, d)
+	if err != nil {
+		return nil, Token{}, err
+	}
+
+	return user, token, nil
+}
+
+// endSession revokes the session of a refresh token
+func (app *Config) endSession(ctx context.Context, refreshToken string) error {
+	current, err := app.Models.RefreshToken.GetByHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+
+	return app.Models.RefreshToken.RevokeFamily(ctx, current.FamilyID)
+}
+
+// validateAccessToken checks the signature and expiry of an access token, and
+// that its session wasn't revoked
+func (app *Config) validateAccessToken(ctx context.Context, token string) (*Claims, error) {
+	claims, err := app.Tokens.Validate(token)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := app.sessionRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errSessionRevoked
+	}
+
+	return claims, nil
+}
+
+// sessionRevoked reports whether the session of an access token was revoked.
+// Tokens issued before sessions existed have none, and are never revoked.
+func (app *Config) sessionRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	if claims.SessionID == "" {
+		return false, nil
+	}
+
+	active, err := app.Models.RefreshToken.FamilyActive(ctx, claims.SessionID)
+	if err != nil {
+		return false, err
+	}
+
+	return !active, nil
+}
+
+// newOpaqueToken returns a random token, like a refresh token, which is only
+// stored hashed
+func newOpaqueToken() string {
+	var b [32]byte
+	_, _ = rand.Read(b[:])
+
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func newSessionID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
+
+// hashToken returns the hex SHA-256 of an opaque token. They are random, so
+// they don't need a slow, salted hash like passwords.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
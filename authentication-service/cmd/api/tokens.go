@@ -0,0 +1,249 @@
+package main
+
+import (
+	"authentication-service/data"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	tokenIssuer       = "authentication-service"
+	defaultTokenTTL   = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+
+	// verificationAudience is the audience of the email verification tokens,
+	// which can't be used as access tokens
+	verificationAudience = "email-verification"
+)
+
+// Claims are the claims carried by an access token.
+type Claims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	// SessionID is the session the token was issued for, revoked on logout
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// VerificationClaims are the claims carried by an email verification token.
+type VerificationClaims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Token is an access token issued to an authenticated user, with the refresh
+// token that gets a new one once it expires.
+type Token struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
+}
+
+// Tokens issues and validates signed access tokens, using either a shared
+// secret (HS256) or an RSA key pair (RS256).
+type Tokens struct {
+	method     jwt.SigningMethod
+	signKey    any
+	verifyKey  any
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+// newTokens configures the token signer from the environment:
+//
+//	JWT_ALGORITHM         HS256 (default) or RS256
+//	JWT_SECRET            shared secret for HS256
+//	JWT_PRIVATE_KEY_FILE  PEM encoded RSA private key for RS256
+//	JWT_PUBLIC_KEY_FILE   PEM encoded RSA public key for RS256 (optional)
+//	JWT_TTL               lifetime of the access tokens, like 15m
+//	REFRESH_TOKEN_TTL     lifetime of the refresh tokens, like 720h
+func newTokens() (*Tokens, error) {
+	t := &Tokens{
+		ttl:        defaultTokenTTL,
+		refreshTTL: defaultRefreshTTL,
+	}
+
+	if ttl := os.Getenv("JWT_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_TTL: %w", err)
+		}
+		t.ttl = d
+	}
+
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REFRESH_TOKEN_TTL: %w", err)
+		}
+		t.refreshTTL = d
+	}
+
+	switch alg := os.Getenv("JWT_ALGORITHM"); alg {
+	case "", "HS256":
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET is required for HS256")
+		}
+
+		t.method = jwt.SigningMethodHS256
+		t.signKey = []byte(secret)
+		t.verifyKey = []byte(secret)
+
+	case "RS256":
+		privateKey, err := readPrivateKey(os.Getenv("JWT_PRIVATE_KEY_FILE"))
+		if err != nil {
+			return nil, err
+		}
+
+		t.method = jwt.SigningMethodRS256
+		t.signKey = privateKey
+		t.verifyKey = &privateKey.PublicKey
+
+		if path := os.Getenv("JWT_PUBLIC_KEY_FILE"); path != "" {
+			publicKey, err := readPublicKey(path)
+			if err != nil {
+				return nil, err
+			}
+			t.verifyKey = publicKey
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %s", alg)
+	}
+
+	return t, nil
+}
+
+// Issue creates a signed access token for user, in the session sessionID.
+func (t *Tokens) Issue(user *data.User, sessionID string) (Token, error) {
+	now := time.Now()
+	expiresAt := now.Add(t.ttl)
+
+	claims := Claims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	signed, err := jwt.NewWithClaims(t.method, claims).SignedString(t.signKey)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return Token{
+		AccessToken: signed,
+		TokenType:   "Bearer",
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// Validate checks the signature and expiry of token and returns its claims.
+func (t *Tokens) Validate(token string) (*Claims, error) {
+	var claims Claims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (any, error) {
+		if token.Method.Alg() != t.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return t.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != tokenIssuer {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	// access tokens have no audience, other tokens are for something else
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("not an access token")
+	}
+
+	return &claims, nil
+}
+
+// IssueVerification creates a signed token verifying the email of user, valid for ttl.
+func (t *Tokens) IssueVerification(user *data.User, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims := VerificationClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{verificationAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+
+	return jwt.NewWithClaims(t.method, claims).SignedString(t.signKey)
+}
+
+// ValidateVerification checks the signature, expiry and audience of an email
+// verification token and returns its claims.
+func (t *Tokens) ValidateVerification(token string) (*VerificationClaims, error) {
+	var claims VerificationClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (any, error) {
+		if token.Method.Alg() != t.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return t.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != tokenIssuer {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	if !claims.VerifyAudience(verificationAudience, true) {
+		return nil, errors.New("not an email verification token")
+	}
+
+	return &claims, nil
+}
+
+func readPrivateKey(path string) (*rsa.PrivateKey, error) {
+	if path == "" {
+		return nil, errors.New("JWT_PRIVATE_KEY_FILE is required for RS256")
+	}
+
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseRSAPrivateKeyFromPEM(pem)
+}
+
+func readPublicKey(path string) (*rsa.PublicKey, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.ParseRSAPublicKeyFromPEM(pem)
+}
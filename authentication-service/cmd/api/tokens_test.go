@@ -0,0 +1,171 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testTokens returns HS256 tokens signed with secret
+func testTokens(secret string) *Tokens {
+	return &Tokens{
+		method:     jwt.SigningMethodHS256,
+		signKey:    []byte(secret),
+		verifyKey:  []byte(secret),
+		ttl:        time.Minute,
+		refreshTTL: time.Hour,
+	}
+}
+
+func testUser() *data.User {
+	return &data.User{ID: 7, Email: "user@example.com", Role: data.RoleUser, Active: 1}
+}
+
+func TestTokensValidate(t *testing.T) {
+	tokens := testTokens("secret")
+
+	issue := func(tokens *Tokens, sessionID string) string {
+		token, err := tokens.Issue(testUser(), sessionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token.AccessToken
+	}
+
+	sign := func(claims jwt.Claims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	verification, err := tokens.IssueVerification(testUser(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := Claims{UserID: 7, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+	}}
+	otherIssuer := Claims{UserID: 7, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    "someone-else",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	otherAudience := Claims{UserID: 7, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{"some-service"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+
+	tests := []struct {
+		name    string
+		token   string
+		session string
+		wantErr string
+	}{
+		{name: "access token", token: issue(tokens, "")},
+		{name: "access token of a session", token: issue(tokens, "session-1"), session: "session-1"},
+		{name: "email verification token", token: verification, wantErr: "not an access token"},
+		{name: "token with an audience", token: sign(otherAudience), wantErr: "not an access token"},
+		{name: "expired token", token: sign(expired), wantErr: "expired"},
+		{name: "other issuer", token: sign(otherIssuer), wantErr: "invalid token issuer"},
+		{name: "other secret", token: issue(testTokens("other-secret"), ""), wantErr: "signature is invalid"},
+		{name: "not a token", token: "not-a-token", wantErr: "token contains an invalid number of segments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tokens.Validate(tt.token)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != 7 || claims.Email != "user@example.com" || claims.Role != data.RoleUser {
+				t.Errorf("got claims %+v", claims)
+			}
+			if claims.SessionID != tt.session {
+				t.Errorf("session = %q, want %q", claims.SessionID, tt.session)
+			}
+		})
+	}
+}
+
+func TestTokensValidateVerification(t *testing.T) {
+	tokens := testTokens("secret")
+
+	verification, err := tokens.IssueVerification(testUser(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := tokens.ValidateVerification(verification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != 7 || claims.Email != "user@example.com" {
+		t.Errorf("got claims %+v", claims)
+	}
+
+	access, err := tokens.Issue(testUser(), "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tokens.ValidateVerification(access.AccessToken)
+	if err == nil {
+		t.Error("access token accepted as an email verification token")
+	}
+}
+
+func TestValidateAccessTokenRevokedSession(t *testing.T) {
+	// the sessions with a refresh token that is neither revoked nor expired
+	active := map[string]bool{"active": true}
+
+	db, _ := openFakeDB(t, func(query string, args []driver.Value) ([][]driver.Value, error) {
+		if !strings.Contains(query, "from refresh_tokens") {
+			return nil, errors.New("unexpected query")
+		}
+		return [][]driver.Value{{active[args[0].(string)]}}, nil
+	})
+
+	app := Config{Tokens: testTokens("secret"), Models: data.New(db)}
+
+	tests := []struct {
+		name    string
+		session string
+		wantErr error
+	}{
+		{name: "token without a session", session: ""},
+		{name: "token of an active session", session: "active"},
+		{name: "token of a revoked session", session: "revoked", wantErr: errSessionRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := app.Tokens.Issue(testUser(), tt.session)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = app.validateAccessToken(context.Background(), token.AccessToken)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	minPasswordLength = 8
+	maxFieldLength    = 255
+)
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errAdminOnly    = errors.New("admin role required")
+)
+
+// invalidInputError is a user input that failed validation
+type invalidInputError struct {
+	err error
+}
+
+func (e *invalidInputError) Error() string {
+	return e.err.Error()
+}
+
+func invalidInput(format string, args ...any) error {
+	return &invalidInputError{err: fmt.Errorf(format, args...)}
+}
+
+// UserInput are the fields of a user set by an admin. On update, a nil Active
+// and an empty Role keep the current values; the password is changed on its own.
+type UserInput struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password,omitempty"`
+	Active    *int   `json:"active,omitempty"`
+	Role      string `json:"role,omitempty"`
+}
+
+// normalizeEmail returns email the way it is stored: lowercased and trimmed.
+// Emails are looked up normalized too, whatever case they are typed in.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// validate normalizes the email of the input and checks its fields. The
+// password is only checked when creating a user.
+func (in *UserInput) validate(creating bool) error {
+	in.Email = normalizeEmail(in.Email)
+
+	if in.Email == "" {
+		return invalidInput("email is required")
+	}
+	if len(in.Email) > maxFieldLength {
+		return invalidInput("email is longer than %d characters", maxFieldLength)
+	}
+	if addr, err := mail.ParseAddress(in.Email); err != nil || addr.Address != in.Email {
+		return invalidInput("email is not a valid address")
+	}
+
+	if len(in.FirstName) > maxFieldLength || len(in.LastName) > maxFieldLength {
+		return invalidInput("names are longer than %d characters", maxFieldLength)
+	}
+
+	if in.Active != nil && *in.Active != 0 && *in.Active != 1 {
+		return invalidInput("active must be 0 or 1")
+	}
+
+	switch in.Role {
+	case "", data.RoleUser, data.RoleAdmin:
+	default:
+		return invalidInput("role must be %s or %s", data.RoleUser, data.RoleAdmin)
+	}
+
+	if creating {
+		return validatePassword(in.Password)
+	}
+
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return invalidInput("password must have at least %d characters", minPasswordLength)
+	}
+
+	// bcrypt ignores what follows the 72nd byte
+	if len(password) > 72 {
+		return invalidInput("password must have at most 72 bytes")
+	}
+
+	return nil
+}
+
+// createUser validates in and inserts the user, refusing emails already in use
+func (app *Config) createUser(ctx context.Context, in UserInput) (*data.User, error) {
+	err := in.validate(true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.checkEmailAvailable(ctx, in.Email, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	user := data.User{
+		Email:     in.Email,
+		FirstName: in.FirstName,
+		LastName:  in.LastName,
+		Password:  in.Password,
+		Active:    1,
+		Role:      in.Role,
+	}
+	if in.Active != nil {
+		user.Active = *in.Active
+	}
+
+	id, err := app.Models.User.Insert(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.getUser(ctx, id)
+}
+
+// getUser returns the user with id, or errUserNotFound
+func (app *Config) getUser(ctx context.Context, id int) (*data.User, error) {
+	user, err := app.Models.User.GetOne(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errUserNotFound
+	}
+
+	return user, err
+}
+
+// updateUser validates in and saves it over the user with id
+func (app *Config) updateUser(ctx context.Context, id int, in UserInput) (*data.User, error) {
+	err := in.validate(false)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.checkEmailAvailable(ctx, in.Email, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the access tokens of the user carry its email and role
+	claimsChanged := user.Email != in.Email || (in.Role != "" && in.Role != user.Role)
+
+	user.Email = in.Email
+	user.FirstName = in.FirstName
+	user.LastName = in.LastName
+	if in.Active != nil {
+		user.Active = *in.Active
+	}
+	if in.Role != "" {
+		user.Role = in.Role
+	}
+
+	err = user.Update(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a deactivated user, or one whose tokens carry an old role or email, is
+	// logged out everywhere: their access tokens are rejected once their
+	// sessions are revoked
+	if user.Active != 1 || claimsChanged {
+		err = app.Models.RefreshToken.RevokeAllForUser(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return app.getUser(ctx, id)
+}
+
+// deleteUser deletes the user with id and returns it
+func (app *Config) deleteUser(ctx context.Context, id int) (*data.User, error) {
+	user, err := app.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.Delete(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// setPassword validates and sets the password of the user with id, and revokes
+// its sessions like a password reset does
+func (app *Config) setPassword(ctx context.Context, id int, password string) (*data.User, error) {
+	err := validatePassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := app.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = user.ResetPassword(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.Models.RefreshToken.RevokeAllForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// checkEmailAvailable returns data.ErrDuplicateEmail when a user other than
+// the one with id has email. The unique index of the table catches the
+// registrations that race with this check.
+func (app *Config) checkEmailAvailable(ctx context.Context, email string, id int) error {
+	user, err := app.Models.User.GetByEmail(ctx, email)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	case user.ID != id:
+		return data.ErrDuplicateEmail
+	default:
+		return nil
+	}
+}
+
+// userErrorStatus is the response status code of an error of the user methods
+func userErrorStatus(err error) int {
+	var invalidErr *invalidInputError
+
+	switch {
+	case errors.As(err, &invalidErr):
+		return http.StatusBadRequest
+	case errors.Is(err, errUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, data.ErrDuplicateEmail):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// userErrorCode is the gRPC counterpart of userErrorStatus
+func userErrorCode(err error) codes.Code {
+	switch userErrorStatus(err) {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"authentication-service/data"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{email: "user@example.com", want: "user@example.com"},
+		{email: "User@Example.COM", want: "user@example.com"},
+		{email: "  user@example.com\t\n", want: "user@example.com"},
+		{email: "   ", want: ""},
+	}
+
+	for _, tt := range tests {
+		got := normalizeEmail(tt.email)
+		if got != tt.want {
+			t.Errorf("normalizeEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
+
+func TestUserInputValidate(t *testing.T) {
+	active := func(n int) *int { return &n }
+	long := strings.Repeat("a", maxFieldLength)
+
+	tests := []struct {
+		name      string
+		input     UserInput
+		creating  bool
+		wantEmail string
+		wantErr   string
+	}{
+		{name: "new user", input: UserInput{Email: "user@example.com", Password: "password", Role: data.RoleAdmin, Active: active(1)}, creating: true, wantEmail: "user@example.com"},
+		{name: "email normalized", input: UserInput{Email: " User@Example.com "}, wantEmail: "user@example.com"},
+		{name: "missing email", input: UserInput{Email: " "}, wantErr: "email is required"},
+		{name: "email with a name", input: UserInput{Email: "User <user@example.com>"}, wantErr: "not a valid address"},
+		{name: "not an email", input: UserInput{Email: "user"}, wantErr: "not a valid address"},
+		{name: "email too long", input: UserInput{Email: long + "@example.com"}, wantErr: "email is longer"},
+		{name: "name too long", input: UserInput{Email: "user@example.com", LastName: long + "a"}, wantErr: "names are longer"},
+		{name: "inactive", input: UserInput{Email: "user@example.com", Active: active(0)}, wantEmail: "user@example.com"},
+		{name: "invalid active", input: UserInput{Email: "user@example.com", Active: active(2)}, wantErr: "active must be 0 or 1"},
+		{name: "invalid role", input: UserInput{Email: "user@example.com", Role: "root"}, wantErr: "role must be"},
+		{name: "password too short", input: UserInput{Email: "user@example.com", Password: "short"}, creating: true, wantErr: "at least"},
+		{name: "password too long", input: UserInput{Email: "user@example.com", Password: strings.Repeat("p", 73)}, creating: true, wantErr: "at most 72 bytes"},
+		{name: "password ignored on update", input: UserInput{Email: "user@example.com", Password: "short"}, wantEmail: "user@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := tt.input
+			err := in.validate(tt.creating)
+
+			if tt.wantErr != "" {
+				var invalid *invalidInputError
+				if !errors.As(err, &invalid) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("err = %v, want an invalid input error with %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if in.Email != tt.wantEmail {
+				t.Errorf("email = %q, want %q", in.Email, tt.wantEmail)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"authentication-service/data"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultVerificationTTL = 24 * time.Hour
+	defaultVerificationURL = "http://localhost/verify-email"
+
+	// verificationTemplate is the template of the mailer the verification links are sent with
+	verificationTemplate = "verify-email"
+)
+
+var (
+	errInvalidVerificationToken = errors.New("invalid or expired verification token")
+	errInactiveUser             = errors.New("user is inactive, verify the email first")
+)
+
+// Verifications configures the email verification links.
+type Verifications struct {
+	url string
+	ttl time.Duration
+}
+
+// newVerifications configures email verifications from the environment:
+//
+//	EMAIL_VERIFICATION_URL  page the verification links point to, with the token as the token query parameter
+//	EMAIL_VERIFICATION_TTL  lifetime of the verification tokens, like 24h
+func newVerifications() (*Verifications, error) {
+	v := &Verifications{
+		url: defaultVerificationURL,
+		ttl: defaultVerificationTTL,
+	}
+
+	if u := os.Getenv("EMAIL_VERIFICATION_URL"); u != "" {
+		v.url = u
+	}
+
+	if ttl := os.Getenv("EMAIL_VERIFICATION_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_VERIFICATION_TTL: %w", err)
+		}
+		v.ttl = d
+	}
+
+	return v, nil
+}
+
+// register creates an inactive user with the user role, and mails it a link
+// to verify its email in the background
+func (app *Config) register(ctx context.Context, in UserInput, requestID string) (*data.User, error) {
+	inactive := 0
+	in.Active = &inactive
+	in.Role = ""
+
+	user, err := app.createUser(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+
+	inBackground(ctx, "sending email verification", func(ctx context.Context) error {
+		return app.sendVerification(ctx, user, requestID)
+	})
+
+	return user, nil
+}
+
+// sendVerification mails a signed link verifying the email of user
+func (app *Config) sendVerification(ctx context.Context, user *data.User, requestID string) error {
+	ctx, span := tracer.Start(ctx, "sendVerification")
+	defer span.End()
+
+	token, err := app.Tokens.IssueVerification(user, app.Verifications.ttl)
+	if err != nil {
+		return err
+	}
+
+	link, err := tokenLink(app.Verifications.url, token)
+	if err != nil {
+		return err
+	}
+
+	return app.Mailer.send(ctx, requestID, user.Email, "Verify your email", verificationTemplate, map[string]string{
+		"name":    user.FirstName,
+		"link":    link,
+		"expires": app.Verifications.ttl.String(),
+	})
+}
+
+// verifyEmail activates the user of a verification token. The token is refused
+// once the user changed since it was issued, so an old link can't activate a
+// user an admin deactivated.
+func (app *Config) verifyEmail(ctx context.Context, token string) (*data.User, error) {
+	claims, err := app.Tokens.ValidateVerification(token)
+	if err != nil {
+		return nil, errInvalidVerificationToken
+	}
+
+	user, err := app.getUser(ctx, claims.UserID)
+	if errors.Is(err, errUserNotFound) {
+		return nil, errInvalidVerificationToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Active == 1 {
+		return user, nil
+	}
+
+	// tokens are issued to the second
+	if user.Email != claims.Email || user.UpdatedAt.Truncate(time.Second).After(claims.IssuedAt.Time) {
+		return nil, errInvalidVerificationToken
+	}
+
+	user.Active = 1
+
+	err = user.Update(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.getUser(ctx, user.ID)
+}
+
+// checkActive returns errInactiveUser for the users that can't log in, because
+// they didn't verify their email or an admin deactivated them
+func checkActive(user *data.User) error {
+	if user.Active != 1 {
+		return errInactiveUser
+	}
+
+	return nil
+}
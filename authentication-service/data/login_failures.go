@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Scopes the failed logins are counted in: the account, by email, and the
+// address the logins come from.
+const (
+	ScopeAccount = "account"
+	ScopeAddress = "address"
+)
+
+// LoginFailure counts the recent failed logins of an account or an address,
+// and locks it out until LockedUntil once there were too many.
+type LoginFailure struct {
+	Scope         string     `json:"scope"`
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty"`
+}
+
+// Get returns the failed logins of the key in scope
+func (f *LoginFailure) Get(ctx context.Context, scope, key string) (*LoginFailure, error) {
+	ctx, done := startQuery(ctx, "login_failures", "LoginFailure.Get")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select scope, key, failures, last_failure_at, locked_until
+	from login_failures where scope = $1 and key = $2`
+
+	return scanLoginFailure(db.QueryRowContext(ctx, query, scope, key))
+}
+
+// Record counts one more failed login of the key in scope and returns its
+// failed logins. The count starts over when the last failure is older than window.
+func (f *LoginFailure) Record(ctx context.Context, scope, key string, window time.Duration) (*LoginFailure, error) {
+	ctx, done := startQuery(ctx, "login_failures", "LoginFailure.Record")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	stmt := `insert into login_failures (scope, key, failures, last_failure_at)
+		values ($1, $2, 1, $3)
+		on conflict (scope, key) do update set
+		failures = case when login_failures.last_failure_at < $4 then 1 else login_failures.failures + 1 end,
+		last_failure_at = $3
+		returning scope, key, failures, last_failure_at, locked_until`
+
+	return scanLoginFailure(db.QueryRowContext(ctx, stmt, scope, key, now, now.Add(-window)))
+}
+
+// Lock locks out the key in scope until the given time. It reports false when
+// it was already locked, so a lockout is only reported once.
+func (f *LoginFailure) Lock(ctx context.Context, scope, key string, until time.Time) (bool, error) {
+	ctx, done := startQuery(ctx, "login_failures", "LoginFailure.Lock")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update login_failures set locked_until = $1
+		where scope = $2 and key = $3 and (locked_until is null or locked_until < $4)`
+
+	result, err := db.ExecContext(ctx, stmt, until, scope, key, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// Clear forgets the failed logins of the key in scope, and unlocks it
+func (f *LoginFailure) Clear(ctx context.Context, scope, key string) error {
+	ctx, done := startQuery(ctx, "login_failures", "LoginFailure.Clear")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `delete from login_failures where scope = $1 and key = $2`
+
+	_, err := db.ExecContext(ctx, stmt, scope, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func scanLoginFailure(row *sql.Row) (*LoginFailure, error) {
+	var failure LoginFailure
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&failure.Scope,
+		&failure.Key,
+		&failure.Failures,
+		&failure.LastFailureAt,
+		&lockedUntil,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		failure.LockedUntil = &lockedUntil.Time
+	}
+
+	return &failure, nil
+}
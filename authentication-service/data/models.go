@@ -7,20 +7,60 @@ import (
 	"log"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
 const dbTimeout = time.Second * 3
 
+// uniqueViolation is the Postgres error code of a duplicate key
+const uniqueViolation = "23505"
+
+// ErrDuplicateEmail is returned when a user is saved with the email of another user.
+var ErrDuplicateEmail = errors.New("a user with this email already exists")
+
 var db *sql.DB
 
+var tracer = otel.Tracer("authentication-service/data")
+
+var queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "authentication",
+	Name:      "db_query_duration_seconds",
+	Help:      "Time taken by database queries, by query.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"query"})
+
+// startQuery starts the span of a call to the database on table. The returned
+// function ends the span and records the duration of the call.
+func startQuery(ctx context.Context, table, name string) (context.Context, func()) {
+	start := time.Now()
+
+	ctx, span := tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBSQLTableKey.String(table)),
+	)
+
+	return ctx, func() {
+		span.End()
+		queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	}
+}
+
 // New is the function used to create an instance of the data package. It returns the type
 // Model, which embeds all the types we want to be available to our application.
 func New(dbPool *sql.DB) Models {
 	db = dbPool
 
 	return Models{
-		User: User{},
+		User:          User{},
+		RefreshToken:  RefreshToken{},
+		PasswordReset: PasswordReset{},
+		LoginFailure:  LoginFailure{},
 	}
 }
 
@@ -28,9 +68,18 @@ func New(dbPool *sql.DB) Models {
 // in this type is available to us throughout the application, anywhere that the
 // app variable is used, provided that the model is also added in the New function.
 type Models struct {
-	User User
+	User          User
+	RefreshToken  RefreshToken
+	PasswordReset PasswordReset
+	LoginFailure  LoginFailure
 }
 
+// Roles a user can have. Admins are allowed to perform administrative actions.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 // User is the structure which holds one user from the database.
 type User struct {
 	ID        int       `json:"id"`
@@ -39,16 +88,20 @@ type User struct {
 	LastName  string    `json:"last_name,omitempty"`
 	Password  string    `json:"-"`
 	Active    int       `json:"active"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // GetAll returns a slice of all users, sorted by last name
-func (u *User) GetAll() ([]*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetAll(ctx context.Context) ([]*User, error) {
+	ctx, done := startQuery(ctx, "users", "User.GetAll")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at
+	query := `select id, email, first_name, last_name, password, user_active, user_role, created_at, updated_at
 	from users order by last_name`
 
 	rows, err := db.QueryContext(ctx, query)
@@ -68,6 +121,7 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.Active,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 		)
@@ -83,11 +137,14 @@ func (u *User) GetAll() ([]*User, error) {
 }
 
 // GetByEmail returns one user by email
-func (u *User) GetByEmail(email string) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, done := startQuery(ctx, "users", "User.GetByEmail")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, user_active, user_role, created_at, updated_at from users where email = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, email)
@@ -99,6 +156,7 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -111,11 +169,14 @@ func (u *User) GetByEmail(email string) (*User, error) {
 }
 
 // GetOne returns one user by id
-func (u *User) GetOne(id int) (*User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) GetOne(ctx context.Context, id int) (*User, error) {
+	ctx, done := startQuery(ctx, "users", "User.GetOne")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, user_role, created_at, updated_at from users where id = $1`
 
 	var user User
 	row := db.QueryRowContext(ctx, query, id)
@@ -127,6 +188,7 @@ func (u *User) GetOne(id int) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -140,8 +202,11 @@ func (u *User) GetOne(id int) (*User, error) {
 
 // Update updates one user in the database, using the information
 // stored in the receiver u
-func (u *User) Update() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) Update(ctx context.Context) error {
+	ctx, done := startQuery(ctx, "users", "User.Update")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `update users set
@@ -149,8 +214,9 @@ func (u *User) Update() error {
 		first_name = $2,
 		last_name = $3,
 		user_active = $4,
-		updated_at = $5
-		where id = $6
+		user_role = $5,
+		updated_at = $6
+		where id = $7
 	`
 
 	_, err := db.ExecContext(ctx, stmt,
@@ -158,20 +224,24 @@ func (u *User) Update() error {
 		u.FirstName,
 		u.LastName,
 		u.Active,
+		u.Role,
 		time.Now(),
 		u.ID,
 	)
 
 	if err != nil {
-		return err
+		return duplicateEmail(err)
 	}
 
 	return nil
 }
 
 // Delete deletes one user from the database, by User.ID
-func (u *User) Delete() error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) Delete(ctx context.Context) error {
+	ctx, done := startQuery(ctx, "users", "User.Delete")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
@@ -185,8 +255,11 @@ func (u *User) Delete() error {
 }
 
 // DeleteByID deletes one user from the database, by ID
-func (u *User) DeleteByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) DeleteByID(ctx context.Context, id int) error {
+	ctx, done := startQuery(ctx, "users", "User.DeleteByID")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	stmt := `delete from users where id = $1`
@@ -200,8 +273,11 @@ func (u *User) DeleteByID(id int) error {
 }
 
 // Insert inserts a new user into the database, and returns the ID of the newly inserted row
-func (u *User) Insert(user User) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) Insert(ctx context.Context, user User) (int, error) {
+	ctx, done := startQuery(ctx, "users", "User.Insert")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
@@ -209,9 +285,13 @@ func (u *User) Insert(user User) (int, error) {
 		return 0, err
 	}
 
+	if user.Role == "" {
+		user.Role = RoleUser
+	}
+
 	var newID int
-	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, user_active, user_role, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	err = db.QueryRowContext(ctx, stmt,
 		user.Email,
@@ -219,20 +299,24 @@ func (u *User) Insert(user User) (int, error) {
 		user.LastName,
 		hashedPassword,
 		user.Active,
+		user.Role,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, duplicateEmail(err)
 	}
 
 	return newID, nil
 }
 
 // ResetPassword is the method we will use to change a user's password.
-func (u *User) ResetPassword(password string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (u *User) ResetPassword(ctx context.Context, password string) error {
+	ctx, done := startQuery(ctx, "users", "User.ResetPassword")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
 	defer cancel()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
@@ -266,3 +350,14 @@ func (u *User) PasswordMatches(plainText string) (bool, error) {
 
 	return true, nil
 }
+
+// duplicateEmail turns the violation of the unique index on the emails of the
+// users into ErrDuplicateEmail
+func duplicateEmail(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrDuplicateEmail
+	}
+
+	return err
+}
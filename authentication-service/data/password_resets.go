@@ -0,0 +1,85 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// PasswordReset is a single-use password reset token of a user, stored by the
+// SHA-256 hash of the token.
+type PasswordReset struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+}
+
+// Insert inserts a new password reset token into the database, and returns its ID
+func (p *PasswordReset) Insert(ctx context.Context, reset PasswordReset) (int, error) {
+	ctx, done := startQuery(ctx, "password_resets", "PasswordReset.Insert")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into password_resets (user_id, token_hash, created_at, expires_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		reset.UserID,
+		reset.TokenHash,
+		time.Now(),
+		reset.ExpiresAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Use marks the unused and unexpired token with the hash as used, and returns
+// the ID of its user. It returns sql.ErrNoRows for any other token, so a token
+// can only be used once even when two resets race.
+func (p *PasswordReset) Use(ctx context.Context, hash string) (int, error) {
+	ctx, done := startQuery(ctx, "password_resets", "PasswordReset.Use")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update password_resets set used_at = $1
+		where token_hash = $2 and used_at is null and expires_at > $1
+		returning user_id`
+
+	var userID int
+	err := db.QueryRowContext(ctx, stmt, time.Now(), hash).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// UseAllForUser marks every unused token of a user as used, once its password
+// was changed
+func (p *PasswordReset) UseAllForUser(ctx context.Context, userID int) error {
+	ctx, done := startQuery(ctx, "password_resets", "PasswordReset.UseAllForUser")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update password_resets set used_at = $1 where user_id = $2 and used_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
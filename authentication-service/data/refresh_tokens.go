@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RefreshToken is one refresh token of a session, stored by the SHA-256 hash of
+// the token. Every rotation of a session adds a token to its family; a session
+// is revoked once all the tokens of its family are.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	TokenHash string     `json:"-"`
+	FamilyID  string     `json:"family_id"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	IPAddress string     `json:"ip_address,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Insert inserts a new refresh token into the database, and returns its ID
+func (t *RefreshToken) Insert(ctx context.Context, token RefreshToken) (int, error) {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.Insert")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into refresh_tokens (user_id, token_hash, family_id, user_agent, ip_address, created_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		token.UserID,
+		token.TokenHash,
+		token.FamilyID,
+		token.UserAgent,
+		token.IPAddress,
+		time.Now(),
+		token.ExpiresAt,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetByHash returns one refresh token by the hash of the token
+func (t *RefreshToken) GetByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.GetByHash")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, token_hash, family_id, user_agent, ip_address, created_at, expires_at, revoked_at
+	from refresh_tokens where token_hash = $1`
+
+	var token RefreshToken
+	var revokedAt sql.NullTime
+	row := db.QueryRowContext(ctx, query, hash)
+
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.FamilyID,
+		&token.UserAgent,
+		&token.IPAddress,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&revokedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// Revoke revokes one refresh token by ID. It reports false when the token was
+// already revoked, so two rotations of the same token can't both succeed.
+func (t *RefreshToken) Revoke(ctx context.Context, id int) (bool, error) {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.Revoke")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where id = $2 and revoked_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// RevokeFamily revokes every refresh token of a session
+func (t *RefreshToken) RevokeFamily(ctx context.Context, familyID string) error {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.RevokeFamily")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every refresh token of every session of a user
+func (t *RefreshToken) RevokeAllForUser(ctx context.Context, userID int) error {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.RevokeAllForUser")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := db.ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FamilyActive reports whether a session still has a refresh token that is
+// neither revoked nor expired
+func (t *RefreshToken) FamilyActive(ctx context.Context, familyID string) (bool, error) {
+	ctx, done := startQuery(ctx, "refresh_tokens", "RefreshToken.FamilyActive")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+
+	query := `select exists (select 1 from refresh_tokens
+	where family_id = $1 and revoked_at is null and expires_at > $2)`
+
+	var active bool
+	err := db.QueryRowContext(ctx, query, familyID, time.Now()).Scan(&active)
+	if err != nil {
+		return false, err
+	}
+
+	return active, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerSettings configures the circuit breakers of the downstreams.
+type BreakerSettings struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int `json:"failure_threshold" yaml:"failure_threshold"`
+	// OpenTimeout is how long the breaker stays open before letting trial requests through
+	OpenTimeout string `json:"open_timeout" yaml:"open_timeout"`
+	// HalfOpenRequests is the number of concurrent trial requests while half-open
+	HalfOpenRequests int `json:"half_open_requests" yaml:"half_open_requests"`
+
+	openTimeout time.Duration
+}
+
+var defaultBreakerSettings = BreakerSettings{
+	FailureThreshold: 5,
+	OpenTimeout:      "30s",
+	HalfOpenRequests: 1,
+	openTimeout:      30 * time.Second,
+}
+
+// validate fills in the defaults and parses the open timeout
+func (settings *BreakerSettings) validate() error {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = defaultBreakerSettings.FailureThreshold
+	}
+
+	if settings.HalfOpenRequests <= 0 {
+		settings.HalfOpenRequests = defaultBreakerSettings.HalfOpenRequests
+	}
+
+	settings.openTimeout = defaultBreakerSettings.openTimeout
+	if settings.OpenTimeout != "" {
+		timeout, err := time.ParseDuration(settings.OpenTimeout)
+		if err != nil {
+			return fmt.Errorf("breaker: %w", err)
+		}
+		settings.openTimeout = timeout
+	}
+
+	return nil
+}
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateClosed:
+		return "closed"
+	case stateOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// CircuitBreaker stops calling a downstream that keeps failing. After
+// FailureThreshold consecutive failures it opens and rejects every request for
+// OpenTimeout; then it lets a few trial requests through (half-open) and closes
+// again if they succeed.
+type CircuitBreaker struct {
+	name     string
+	settings *Breakers
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// Allow reports whether a request may be sent to the downstream.
+func (cb *CircuitBreaker) Allow() error {
+	settings := cb.settings.Settings()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case stateOpen:
+		if time.Since(cb.openedAt) < settings.openTimeout {
+			return errCircuitOpen
+		}
+		cb.setState(stateHalfOpen)
+		fallthrough
+
+	case stateHalfOpen:
+		if cb.trials >= settings.HalfOpenRequests {
+			return errCircuitOpen
+		}
+		cb.trials++
+	}
+
+	return nil
+}
+
+// Record updates the breaker with the outcome of a request. Only errors that
+// mean the downstream is unavailable count as failures.
+func (cb *CircuitBreaker) Record(err error) {
+	settings := cb.settings.Settings()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if err == nil || !isUnavailable(err) {
+		cb.failures = 0
+		if cb.state == stateHalfOpen {
+			cb.setState(stateClosed)
+		}
+		return
+	}
+
+	cb.failures++
+
+	if cb.state == stateHalfOpen || cb.failures >= settings.FailureThreshold {
+		cb.setState(stateOpen)
+		cb.openedAt = time.Now()
+	}
+}
+
+// Release gives back the trial taken by a request that ended without an
+// outcome, like one cancelled by its client, so another request can try.
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == stateHalfOpen && cb.trials > 0 {
+		cb.trials--
+	}
+}
+
+func (cb *CircuitBreaker) setState(state breakerState) {
+	if cb.state == state {
+		return
+	}
+
+	log.Printf("Circuit breaker for %s is %s\n", cb.name, state)
+
+	cb.state = state
+	cb.trials = 0
+	if state == stateClosed {
+		cb.failures = 0
+	}
+}
+
+// Breakers holds one circuit breaker per downstream.
+type Breakers struct {
+	mu       sync.RWMutex
+	settings BreakerSettings
+	breakers map[string]*CircuitBreaker
+}
+
+func newBreakers(settings BreakerSettings) *Breakers {
+	return &Breakers{
+		settings: settings,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// For returns the breaker of downstream, creating it if needed.
+func (b *Breakers) For(downstream string) *CircuitBreaker {
+	b.mu.RLock()
+	cb, ok := b.breakers[downstream]
+	b.mu.RUnlock()
+	if ok {
+		return cb
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	cb, ok = b.breakers[downstream]
+	if !ok {
+		cb = &CircuitBreaker{
+			name:     downstream,
+			settings: b,
+		}
+		b.breakers[downstream] = cb
+	}
+
+	return cb
+}
+
+// Settings returns the current breaker settings.
+func (b *Breakers) Settings() BreakerSettings {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.settings
+}
+
+// Configure replaces the breaker settings, keeping the state of every breaker.
+func (b *Breakers) Configure(settings BreakerSettings) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.settings = settings
+}
+
+// backoff returns the delay before retry number attempt+1: an exponential
+// delay with full jitter, so clients retrying together don't stay in step.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << attempt
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
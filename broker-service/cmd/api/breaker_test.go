@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBreakers returns breakers opening after threshold failures for openTimeout
+func testBreakers(t *testing.T, threshold int, openTimeout string) *Breakers {
+	t.Helper()
+
+	settings := BreakerSettings{FailureThreshold: threshold, OpenTimeout: openTimeout, HalfOpenRequests: 1}
+	err := settings.validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return newBreakers(settings)
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	down := unavailable("logger-service", errors.New("connection refused"))
+	refused := errors.New("invalid payload")
+
+	tests := []struct {
+		name string
+		// outcomes are recorded in order, each after an allowed request
+		outcomes []error
+		wait     time.Duration
+		want     breakerState
+		allowed  bool
+	}{
+		{name: "closed below the threshold", outcomes: []error{down, down}, want: stateClosed, allowed: true},
+		{name: "opens at the threshold", outcomes: []error{down, down, down}, want: stateOpen, allowed: false},
+		{name: "other errors reset the count", outcomes: []error{down, down, refused, down, down}, want: stateClosed, allowed: true},
+		{name: "successes reset the count", outcomes: []error{down, down, nil, down}, want: stateClosed, allowed: true},
+		{name: "half-open after the timeout", outcomes: []error{down, down, down}, wait: 30 * time.Millisecond, want: stateHalfOpen, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := testBreakers(t, 3, "20ms").For("logger-service")
+
+			for _, outcome := range tt.outcomes {
+				if err := cb.Allow(); err != nil {
+					t.Fatalf("request rejected before the outcomes were recorded: %v", err)
+				}
+				cb.Record(outcome)
+			}
+
+			time.Sleep(tt.wait)
+
+			err := cb.Allow()
+			if allowed := err == nil; allowed != tt.allowed {
+				t.Errorf("allowed = %v, want %v", allowed, tt.allowed)
+			}
+			if err != nil && !errors.Is(err, errCircuitOpen) {
+				t.Errorf("err = %v, want errCircuitOpen", err)
+			}
+			if cb.state != tt.want {
+				t.Errorf("state = %s, want %s", cb.state, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	down := unavailable("logger-service", errors.New("connection refused"))
+
+	tests := []struct {
+		name    string
+		outcome error
+		want    breakerState
+	}{
+		{name: "closes after a successful trial", outcome: nil, want: stateClosed},
+		{name: "opens again after a failed trial", outcome: down, want: stateOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := testBreakers(t, 1, "10ms").For("logger-service")
+
+			_ = cb.Allow()
+			cb.Record(down)
+			time.Sleep(20 * time.Millisecond)
+
+			if err := cb.Allow(); err != nil {
+				t.Fatalf("trial request rejected: %v", err)
+			}
+			if err := cb.Allow(); !errors.Is(err, errCircuitOpen) {
+				t.Fatalf("second concurrent trial allowed, err = %v", err)
+			}
+
+			cb.Record(tt.outcome)
+
+			if cb.state != tt.want {
+				t.Errorf("state = %s, want %s", cb.state, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakersFor(t *testing.T) {
+	breakers := testBreakers(t, 3, "1s")
+
+	if breakers.For("rabbitmq") != breakers.For("rabbitmq") {
+		t.Error("a downstream got two breakers")
+	}
+	if breakers.For("rabbitmq") == breakers.For("logger-service") {
+		t.Error("two downstreams share a breaker")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 0, max: retryBaseDelay},
+		{attempt: 1, max: 2 * retryBaseDelay},
+		{attempt: 3, max: 8 * retryBaseDelay},
+		{attempt: 10, max: retryMaxDelay},
+		{attempt: 100, max: retryMaxDelay},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			delay := backoff(tt.attempt)
+			if delay <= 0 || delay > tt.max {
+				t.Fatalf("backoff(%d) = %s, want in (0, %s]", tt.attempt, delay, tt.max)
+			}
+		}
+	}
+}
+
+func TestDispatchStopsRetryingWhenCancelled(t *testing.T) {
+	app := Config{Breakers: testBreakers(t, 100, "1s")}
+
+	route := Route{
+		Action:     "log-json",
+		Service:    "logger-service",
+		Transport:  "http",
+		Address:    "127.0.0.1:1",
+		Method:     "/log",
+		Idempotent: true,
+		Retries:    5,
+		timeout:    time.Second,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := app.dispatch(ctx, route, RequestPayload{})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > retryBaseDelay {
+		t.Errorf("dispatch took %s, it slept through the retries", elapsed)
+	}
+}
+
+func TestDispatchClientGaveUp(t *testing.T) {
+	// the service answers after the client and the route gave up
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name          string
+		clientTimeout time.Duration
+		routeTimeout  time.Duration
+		wantErr       error
+		wantState     breakerState
+	}{
+		{name: "client deadline", clientTimeout: 20 * time.Millisecond, routeTimeout: time.Second, wantErr: context.DeadlineExceeded, wantState: stateClosed},
+		{name: "route timeout", clientTimeout: time.Second, routeTimeout: 20 * time.Millisecond, wantState: stateOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := Config{Breakers: testBreakers(t, 1, "1s")}
+
+			route := Route{
+				Action:    "log-json",
+				Service:   "logger-service",
+				Transport: "http",
+				Address:   strings.TrimPrefix(server.URL, "http://"),
+				Method:    "/log",
+				timeout:   tt.routeTimeout,
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.clientTimeout)
+			defer cancel()
+
+			_, err := app.dispatch(ctx, route, RequestPayload{})
+
+			switch {
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			case tt.wantErr == nil && !isUnavailable(err):
+				t.Errorf("err = %v, want an unavailable error", err)
+			}
+
+			if state := app.Breakers.For("logger-service").state; state != tt.wantState {
+				t.Errorf("state = %s, want %s", state, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	cb := testBreakers(t, 1, "10ms").For("logger-service")
+
+	_ = cb.Allow()
+	cb.Record(unavailable("logger-service", errors.New("connection refused")))
+	time.Sleep(20 * time.Millisecond)
+
+	if err := cb.Allow(); err != nil {
+		t.Fatalf("trial request rejected: %v", err)
+	}
+
+	// the trial was cancelled by its client, another request may try
+	cb.Release()
+
+	if err := cb.Allow(); err != nil {
+		t.Errorf("trial request rejected after a release: %v", err)
+	}
+	if cb.state != stateHalfOpen {
+		t.Errorf("state = %s, want %s", cb.state, stateHalfOpen)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	dialTimeout         = time.Second
+	healthCheckInterval = 10 * time.Second
+)
+
+// grpcClients keeps one long-lived connection per address, shared by every
+// handler. Connections are created on first use and reconnect by themselves.
+type grpcClients struct {
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+	done  chan struct{}
+}
+
+func newGRPCClients() *grpcClients {
+	c := &grpcClients{
+		conns: make(map[string]*grpc.ClientConn),
+		done:  make(chan struct{}),
+	}
+
+	go c.healthCheck()
+
+	return c
+}
+
+// Get returns the connection to address, dialing it if needed. Dialing does
+// not block: the connection is established in the background and calls wait
+// for it within their own deadline.
+func (c *grpcClients) Get(address string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conn, ok := c.conns[address]
+	if ok && conn.GetState() != connectivity.Shutdown {
+		return conn, nil
+	}
+
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conns[address] = conn
+
+	return conn, nil
+}
+
+// healthCheck periodically checks the state of every connection, waking up
+// idle connections and skipping the backoff of the ones that failed, so they
+// are ready before the next request arrives.
+func (c *grpcClients) healthCheck() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.Lock()
+		for address, conn := range c.conns {
+			switch conn.GetState() {
+			case connectivity.Idle:
+				conn.Connect()
+			case connectivity.TransientFailure:
+				log.Printf("gRPC connection to %s is failing, reconnecting\n", address)
+				conn.ResetConnectBackoff()
+			case connectivity.Shutdown:
+				delete(c.conns, address)
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// Close closes every connection.
+func (c *grpcClients) Close() {
+	close(c.done)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for address, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, address)
+	}
+}
+
+// rpcClients keeps one long-lived net/rpc client per address. A client whose
+// connection is lost is dropped and dialed again on the next call.
+type rpcClients struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+func newRPCClients() *rpcClients {
+	return &rpcClients{
+		clients: make(map[string]*rpc.Client),
+	}
+}
+
+// Call invokes serviceMethod on the server at address, waiting at most timeout.
+func (c *rpcClients) Call(address, serviceMethod string, args any, reply any, timeout time.Duration) error {
+	err := c.call(address, serviceMethod, args, reply, timeout)
+	if errors.Is(err, rpc.ErrShutdown) {
+		// the connection was already broken, so the call never reached the server
+		err = c.call(address, serviceMethod, args, reply, timeout)
+	}
+
+	return err
+}
+
+func (c *rpcClients) call(address, serviceMethod string, args any, reply any, timeout time.Duration) error {
+	client, err := c.get(address)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(serviceMethod, args, reply, nil)
+
+	select {
+	case <-call.Done:
+	case <-time.After(timeout):
+		// the connection may be stuck, the next call dials a new one
+		c.drop(address, client)
+		return fmt.Errorf("timeout calling %s", serviceMethod)
+	}
+
+	// errors returned by the remote method leave the connection usable, any
+	// other error means it is broken
+	var serverErr rpc.ServerError
+	if call.Error != nil && !errors.As(call.Error, &serverErr) {
+		c.drop(address, client)
+	}
+
+	return call.Error
+}
+
+func (c *rpcClients) get(address string) (*rpc.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[address]; ok {
+		return client, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	client := rpc.NewClient(conn)
+	c.clients[address] = client
+
+	return client, nil
+}
+
+// drop removes client from the pool, unless it was already replaced
+func (c *rpcClients) drop(address string, client *rpc.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.clients[address] == client {
+		delete(c.clients, address)
+		client.Close()
+	}
+}
+
+// Close closes every client.
+func (c *rpcClients) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for address, client := range c.clients {
+		client.Close()
+		delete(c.clients, address)
+	}
+}
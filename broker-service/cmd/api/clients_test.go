@@ -0,0 +1,201 @@
+package main
+
+import (
+	"broker-service/logs"
+	"context"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Echo is a net/rpc service answering its argument, or waiting for it first
+type Echo struct{}
+
+func (Echo) Say(args string, reply *string) error {
+	*reply = args
+	return nil
+}
+
+func (Echo) Sleep(d time.Duration, reply *string) error {
+	time.Sleep(d)
+	*reply = "slept"
+	return nil
+}
+
+// startRPCServer serves Echo over net/rpc and returns its address
+func startRPCServer(tb testing.TB) string {
+	tb.Helper()
+
+	server := rpc.NewServer()
+	err := server.Register(Echo{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listen.Close() })
+
+	go func() {
+		for {
+			conn, err := listen.Accept()
+			if err != nil {
+				return
+			}
+			go server.ServeConn(conn)
+		}
+	}()
+
+	return listen.Addr().String()
+}
+
+// logServer answers every log entry
+type logServer struct {
+	logs.UnimplementedLogServiceServer
+}
+
+func (logServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.LogResponse, error) {
+	return &logs.LogResponse{Result: "logged"}, nil
+}
+
+// startGRPCServer serves logServer over gRPC and returns its address
+func startGRPCServer(tb testing.TB) string {
+	tb.Helper()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s := grpc.NewServer()
+	logs.RegisterLogServiceServer(s, logServer{})
+	go s.Serve(listen)
+	tb.Cleanup(s.Stop)
+
+	return listen.Addr().String()
+}
+
+func TestRPCClientsReuseConnection(t *testing.T) {
+	address := startRPCServer(t)
+
+	clients := newRPCClients()
+	defer clients.Close()
+
+	var reply string
+	for i := 0; i < 3; i++ {
+		err := clients.Call(address, "Echo.Say", "hello", &reply, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reply != "hello" || len(clients.clients) != 1 {
+		t.Errorf("reply = %q with %d clients, want hello with 1", reply, len(clients.clients))
+	}
+}
+
+func TestRPCClientsDropClientOnTimeout(t *testing.T) {
+	address := startRPCServer(t)
+
+	clients := newRPCClients()
+	defer clients.Close()
+
+	var reply string
+	err := clients.Call(address, "Echo.Sleep", 200*time.Millisecond, &reply, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+
+	if len(clients.clients) != 0 {
+		t.Fatal("the client of the timed out call was kept")
+	}
+
+	err = clients.Call(address, "Echo.Say", "again", &reply, time.Second)
+	if err != nil || reply != "again" {
+		t.Fatalf("reply = %q, err = %v after the timeout", reply, err)
+	}
+}
+
+// BenchmarkRPCDialPerCall is how the broker called net/rpc services before
+// the clients were shared: a connection per call.
+func BenchmarkRPCDialPerCall(b *testing.B) {
+	address := startRPCServer(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		var reply string
+		err = client.Call("Echo.Say", "hello", &reply)
+		client.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRPCPooled(b *testing.B) {
+	address := startRPCServer(b)
+
+	clients := newRPCClients()
+	defer clients.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var reply string
+		err := clients.Call(address, "Echo.Say", "hello", &reply, time.Second)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGRPCDialPerCall is how the broker called gRPC services before the
+// connections were shared: a connection per call.
+func BenchmarkGRPCDialPerCall(b *testing.B) {
+	address := startGRPCServer(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = logs.NewLogServiceClient(conn).WriteLog(context.Background(), &logs.LogRequest{})
+		conn.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGRPCPooled(b *testing.B) {
+	address := startGRPCServer(b)
+
+	clients := newGRPCClients()
+	defer clients.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conn, err := clients.Get(address)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		_, err = logs.NewLogServiceClient(conn).WriteLog(context.Background(), &logs.LogRequest{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,50 +2,78 @@ package main
 
 import (
 	"broker-service/auths"
-	"broker-service/event"
 	"broker-service/logs"
 	"broker-service/mails"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/rpc"
+	"strconv"
 	"time"
 
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/go-chi/chi/v5"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type RequestPayload struct {
-	Action string      `json:"action"`
-	Auth   AuthPayload `json:"auth,omitempty"`
-	Log    LogPayload  `json:"log,omitempty"`
-	Mail   MailPayload `json:"mail,omitempty"`
+	Action string          `json:"action"`
+	Auth   AuthPayload     `json:"auth,omitempty"`
+	Log    LogPayload      `json:"log,omitempty"`
+	Mail   MailPayload     `json:"mail,omitempty"`
+	User   UserPayload     `json:"user,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
 }
 
 type MailPayload struct {
-	Name    string `json:"name"`
-	From    string `json:"from"`
-	To      string `json:"to"`
-	Subject string `json:"subject"`
-	Message string `json:"message"`
+	RequestID    string            `json:"-"`
+	TraceContext map[string]string `json:"-"`
+	Name         string            `json:"name"`
+	From         string            `json:"from"`
+	To           string            `json:"to"`
+	Subject      string            `json:"subject"`
+	Message      string            `json:"message"`
 }
 
 type AuthPayload struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	RequestID    string            `json:"-"`
+	TraceContext map[string]string `json:"-"`
+	// ClientAddress is set by the broker, never by the client, and lets the
+	// listener pass the address of the client on with auth.CHECK events
+	ClientAddress     string `json:"client_address,omitempty"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	Password          string `json:"password"`
+	FirstName         string `json:"first_name,omitempty"`
+	LastName          string `json:"last_name,omitempty"`
+	RefreshToken      string `json:"refresh_token,omitempty"`
+	ResetToken        string `json:"reset_token,omitempty"`
+	VerificationToken string `json:"verification_token,omitempty"`
 }
 
-type LogPayload struct {
-	Name string `json:"name"`
-	Data string `json:"data"`
+// TokenPayload is the access token issued by the authentication service
+type TokenPayload struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token,omitempty"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at,omitempty"`
 }
 
-type RabbitPayload struct {
-	Severity string
-	Data     any
+type LogPayload struct {
+	RequestID    string            `json:"-"`
+	TraceContext map[string]string `json:"-"`
+	Name         string            `json:"name"`
+	Data         string            `json:"data"`
 }
 
 func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
@@ -59,6 +87,7 @@ func (app *Config) Broker(w http.ResponseWriter, r *http.Request) {
 
 // HandleSubmission is the main point of entry into the broker. It accepts a JSON
 // payload and performs an action based on the value of "action" in that JSON.
+// The service that handles each action is looked up in the route registry.
 func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 	var requestPayload RequestPayload
 
@@ -68,189 +97,377 @@ func (app *Config) HandleSubmission(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	switch requestPayload.Action {
-	case "auth-json":
-		app.authenticate(w, requestPayload.Auth)
-	case "auth-rabbit":
-		app.rabbitRequest(w, requestPayload.Auth, "auth.CHECK", "Authenticated via RabbitMQ")
-	case "auth-rpc":
-		app.rpcRequest(w, "authentication-service:5001", "RPCServer.AuthenticateViaRPC", requestPayload.Auth)
-	case "auth-grpc":
-		app.authenticateViaGRPC(w, requestPayload.Auth)
-	case "log-json":
-		app.logItem(w, requestPayload.Log)
-	case "log-rabbit":
-		app.rabbitRequest(w, requestPayload.Log, "log.INFO", "Logged via RabbitMQ")
-	case "log-rpc":
-		app.rpcRequest(w, "logger-service:5001", "RPCServer.LogInfo", requestPayload.Log)
-	case "log-grpc":
-		app.logViaGRPC(w, requestPayload.Log)
-	case "mail-json":
-		app.sendMail(w, requestPayload.Mail)
-	case "mail-rabbit":
-		app.rabbitRequest(w, requestPayload.Mail, "mail.SEND", "Mail sent via RabbitMQ")
-	case "mail-rpc":
-		app.rpcRequest(w, "mailer-service:5001", "RPCServer.SendMailViaRPC", requestPayload.Mail)
-	case "mail-grpc":
-		app.sendMailViaGRPC(w, requestPayload.Mail)
-	default:
+	route, ok := app.Routes.Lookup(requestPayload.Action)
+	if !ok {
 		app.errorJSON(w, errors.New("unknown action"))
+		return
 	}
-}
-
-// logItem logs an event to the database
-func (app *Config) logItem(w http.ResponseWriter, entry LogPayload) {
-	jsonData, _ := json.MarshalIndent(entry, "", "\t")
 
-	logServiceURL := "http://logger-service/log"
+	requestPayload.setRequestID(requestIDFromContext(r.Context()))
+	requestPayload.Auth.ClientAddress = clientAddressFromContext(r.Context())
 
-	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
+	start := time.Now()
+	payload, err := app.dispatch(r.Context(), route, requestPayload)
+	observeRequest(route, start, err)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
 
-	response, err := client.Do(request)
+// GetJob returns a job started by the principal (admins can see every job). With
+// the wait query parameter (like wait=30s) it long-polls: the response is sent as
+// soon as the job is finished, or when the wait is over.
+func (app *Config) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := app.Jobs.Get(r.Context(), chi.URLParam(r, "id"))
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusNotFound)
 		return
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, err)
+	principal, _ := principalFromContext(r.Context())
+	if job.UserID != principal.UserID && principal.Role != roleAdmin {
+		app.errorJSON(w, ErrJobNotFound, http.StatusNotFound)
 		return
 	}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Logged via JSON"
+	if wait := r.URL.Query().Get("wait"); wait != "" && !job.Finished() {
+		timeout, err := time.ParseDuration(wait)
+		if err != nil {
+			app.errorJSON(w, fmt.Errorf("invalid wait: %w", err))
+			return
+		}
+
+		if timeout > maxJobWait {
+			timeout = maxJobWait
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		job, err = app.Jobs.Wait(ctx, job.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusNotFound)
+			return
+		}
+	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("job %s", job.Status),
+		Data:    job,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// authenticate calls the authentication microservice and sends back the appropriate response
-func (app *Config) authenticate(w http.ResponseWriter, a AuthPayload) {
-	// create some json we'll send to the auth microservice
-	jsonData, _ := json.MarshalIndent(a, "", "\t")
+// ListOutbox returns the outbox entries that were not published yet: the
+// pending ones, waiting for their first or next attempt, and the failed ones.
+// The status query parameter selects one of them, and limit the number of
+// entries (100 by default).
+func (app *Config) ListOutbox(w http.ResponseWriter, r *http.Request) {
+	if app.Outbox == nil {
+		app.errorJSON(w, errors.New("the outbox is disabled"), http.StatusNotFound)
+		return
+	}
 
-	// call the service
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		app.errorJSON(w, err)
+	statuses := []string{OutboxPending, OutboxFailed}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case OutboxPending, OutboxFailed, OutboxSent:
+		statuses = []string{status}
+	default:
+		app.errorJSON(w, fmt.Errorf("invalid status %s", status))
 		return
 	}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	limit := outboxBatch
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			app.errorJSON(w, fmt.Errorf("invalid limit %s", l))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := app.Outbox.List(r.Context(), statuses, limit)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	defer response.Body.Close()
 
-	// make sure we get back the correct status code
-	if response.StatusCode == http.StatusUnauthorized {
-		app.errorJSON(w, errors.New("invalid credentials"))
-		return
-	} else if response.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("error calling auth service"))
-		return
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d outbox entries", len(entries)),
+		Data:    entries,
 	}
 
-	// create a variable we'll read response.Body into
-	var jsonFromService jsonResponse
+	app.writeJSON(w, http.StatusOK, payload)
+}
 
-	// decode the json from the auth service
-	err = json.NewDecoder(response.Body).Decode(&jsonFromService)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+// dispatch calls the service behind route through the circuit breaker of its
+// downstream. Idempotent routes are retried, with a jittered backoff, while the
+// downstream is unavailable.
+func (app *Config) dispatch(ctx context.Context, route Route, requestPayload RequestPayload) (jsonResponse, error) {
+	breaker := app.Breakers.For(route.downstream())
+
+	for attempt := 0; ; attempt++ {
+		err := breaker.Allow()
+		if err != nil {
+			observeDownstreamError(route, err)
+			return jsonResponse{}, unavailable(route.downstream(), err)
+		}
+
+		payload, err := app.call(ctx, route, requestPayload)
+
+		// a client that gave up tells nothing about the downstream
+		if ctx.Err() != nil {
+			breaker.Release()
+			return jsonResponse{}, ctx.Err()
+		}
+
+		breaker.Record(err)
+		if err != nil {
+			observeDownstreamError(route, err)
+		}
+
+		if err == nil || !isUnavailable(err) || !route.Idempotent || attempt >= route.Retries {
+			return payload, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return jsonResponse{}, ctx.Err()
+		case <-time.After(backoff(attempt)):
+		}
 	}
+}
 
-	if jsonFromService.Error {
-		app.errorJSON(w, err, http.StatusUnauthorized)
-		return
+// call performs one request to the service behind route
+func (app *Config) call(ctx context.Context, route Route, requestPayload RequestPayload) (jsonResponse, error) {
+	payload := requestPayload.forService(route.Service)
+
+	switch route.Transport {
+	case "http":
+		return app.httpRequest(ctx, route, payload)
+	case "rpc":
+		return app.rpcRequest(ctx, route, requestPayload)
+	case "grpc":
+		return app.grpcRequest(ctx, route, requestPayload)
+	case "amqp":
+		if route.Reply {
+			return app.rabbitCall(ctx, route, payload)
+		}
+		return app.rabbitRequest(ctx, route, payload)
+	default:
+		return jsonResponse{}, errors.New("unknown action")
 	}
+}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Authenticated via JSON"
-	payload.Data = jsonFromService.Data
+// setRequestID sets the request ID sent along with the typed payloads over net/rpc
+func (p *RequestPayload) setRequestID(id string) {
+	p.Auth.RequestID = id
+	p.Log.RequestID = id
+	p.Mail.RequestID = id
+}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+// setTraceContext sets the trace context sent along with the typed payloads over net/rpc
+func (p *RequestPayload) setTraceContext(carrier map[string]string) {
+	p.Auth.TraceContext = carrier
+	p.Log.TraceContext = carrier
+	p.Mail.TraceContext = carrier
+}
+
+// forService returns the part of the request that is sent to service. Services
+// the broker has no payload type for receive the raw "data" object.
+func (p RequestPayload) forService(service string) any {
+	switch service {
+	case "authentication-service":
+		return p.Auth
+	case "logger-service":
+		return p.Log
+	case "mailer-service":
+		return p.Mail
+	default:
+		return p.Data
+	}
 }
 
-func (app *Config) sendMail(w http.ResponseWriter, msg MailPayload) {
-	jsonData, _ := json.MarshalIndent(msg, "", "\t")
+// httpRequest posts the payload as JSON to the route, with the access token of
+// the principal, and returns the service's response
+func (app *Config) httpRequest(ctx context.Context, route Route, data any) (jsonResponse, error) {
+	jsonData, _ := json.MarshalIndent(data, "", "\t")
 
-	// call the mail service
-	mailServiceURL := "http://mailer-service/send"
+	// call the service
+	serviceURL := fmt.Sprintf("http://%s%s", route.Address, route.Method)
 
-	// post to mail service
-	request, err := http.NewRequest("POST", mailServiceURL, bytes.NewBuffer(jsonData))
+	request, err := http.NewRequestWithContext(ctx, "POST", serviceURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(requestIDHeader, requestIDFromContext(ctx))
+	request.Header.Set("X-Forwarded-For", clientAddressFromContext(ctx))
+	if principal, ok := principalFromContext(ctx); ok && principal.token != "" {
+		request.Header.Set("Authorization", "Bearer "+principal.token)
+	}
 
-	client := &http.Client{}
+	client := &http.Client{
+		Timeout:   route.timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
 	response, err := client.Do(request)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, callError(ctx, route.Service, err)
 	}
 	defer response.Body.Close()
 
-	// make sure we get back the right status code
-	if response.StatusCode != http.StatusAccepted {
-		app.errorJSON(w, errors.New("error calling mail service"))
-		return
+	payload, err := serviceResponse(route, response.StatusCode, response.Body)
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	// mails are answered with their recipient
+	if mail, ok := data.(MailPayload); ok && mail.To != "" {
+		payload.Message = fmt.Sprintf("%s to %s", route.Message, mail.To)
+	}
+
+	return payload, nil
+}
+
+// serviceResponse turns the status code and JSON body answered by the service
+// behind route into the response of the broker. Only the authentication
+// service answers for the credentials of the client: the errors of the other
+// services are bad requests (400) when they refused the payload, and bad
+// gateways (502) when their response makes no sense.
+func serviceResponse(route Route, status int, body io.Reader) (jsonResponse, error) {
+	auth := route.Service == "authentication-service"
+
+	// decode the json from the service, error responses included
+	var jsonFromService jsonResponse
+	decodeErr := json.NewDecoder(body).Decode(&jsonFromService)
+
+	message := jsonFromService.Message
+	if decodeErr != nil || message == "" {
+		message = fmt.Sprintf("error calling %s", route.Service)
+	}
+
+	// make sure we get back the correct status code
+	switch {
+	case status == http.StatusUnauthorized && auth:
+		return jsonResponse{}, withStatus(http.StatusUnauthorized, errors.New("invalid credentials"))
+	case status == http.StatusTooManyRequests:
+		return jsonResponse{}, withStatus(status, errors.New("too many failed logins, try again later"))
+	case status >= http.StatusInternalServerError:
+		return jsonResponse{}, unavailable(route.Service, fmt.Errorf("%s responded %d %s", route.Service, status, http.StatusText(status)))
+	case status >= http.StatusBadRequest && auth:
+		return jsonResponse{}, withStatus(status, errors.New(message))
+	case status >= http.StatusBadRequest && status != http.StatusUnauthorized:
+		return jsonResponse{}, withStatus(http.StatusBadRequest, errors.New(message))
+	case status != http.StatusAccepted:
+		return jsonResponse{}, withStatus(http.StatusBadGateway, fmt.Errorf("%s responded %d %s", route.Service, status, http.StatusText(status)))
+	}
+
+	if decodeErr != nil {
+		return jsonResponse{}, withStatus(http.StatusBadGateway, fmt.Errorf("decoding response of %s: %w", route.Service, decodeErr))
+	}
+
+	if jsonFromService.Error {
+		if auth {
+			return jsonResponse{}, withStatus(http.StatusUnauthorized, errors.New(jsonFromService.Message))
+		}
+		return jsonResponse{}, withStatus(http.StatusBadRequest, errors.New(jsonFromService.Message))
 	}
 
-	// send back json
 	var payload jsonResponse
 	payload.Error = false
-	payload.Message = "Message sent via JSON to " + msg.To
+	payload.Message = route.Message
+	payload.Data = jsonFromService.Data
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
 }
 
-// rabbitRequest pushes the data to RabbitMQ.
-func (app *Config) rabbitRequest(w http.ResponseWriter, rabbitPayload any, severity, msg string) {
-	rpaylod := RabbitPayload{
-		Severity: severity,
-		Data:     rabbitPayload,
+// rabbitRequest pushes the data to RabbitMQ as a new job, which is sent back so
+// the client can follow it on /jobs/{id}.
+func (app *Config) rabbitRequest(ctx context.Context, route Route, rabbitPayload any) (jsonResponse, error) {
+	now := time.Now()
+	job := Job{
+		ID:        newUUID(),
+		Action:    route.Action,
+		Status:    JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
 
-	err := app.pushToQueue(rpaylod)
+	if principal, ok := principalFromContext(ctx); ok {
+		job.UserID = principal.UserID
+	}
+
+	err := app.Jobs.Create(ctx, job)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
+	}
+
+	err = app.pushToQueue(ctx, route.Method, rabbitPayload, job.ID)
+	if err != nil {
+		_ = app.Jobs.Update(ctx, job.ID, JobFailed, err.Error())
+		return jsonResponse{}, callError(ctx, "rabbitmq", err)
 	}
 
 	var payload jsonResponse
 	payload.Error = false
-	payload.Message = msg
+	payload.Message = route.Message
+	payload.Data = job
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
 }
 
-// pushToQueue pushes a message into RabbitMQ
-func (app *Config) pushToQueue(payload RabbitPayload) error {
-	emitter, err := event.NewEventEmitter(app.Rabbit)
+// rabbitCall publishes the payload with the route's routing key and waits, up to
+// the route's timeout, for the reply of the consumer, which carries the status
+// code and JSON body answered by the service.
+func (app *Config) rabbitCall(ctx context.Context, route Route, rabbitPayload any) (jsonResponse, error) {
+	request := ctx
+	ctx, cancel := context.WithTimeout(ctx, route.timeout)
+	defer cancel()
+
+	j, _ := json.Marshal(rabbitPayload)
+	headers := amqp.Table{
+		requestIDAMQPHeader: requestIDFromContext(ctx),
+	}
+
+	reply, err := app.Caller.Call(ctx, route.Method, j, headers)
 	if err != nil {
-		return err
+		return jsonResponse{}, callError(request, "rabbitmq", err)
+	}
+
+	status, _ := reply.Headers[statusAMQPHeader].(int32)
+
+	return serviceResponse(route, int(status), bytes.NewReader(reply.Body))
+}
+
+// pushToQueue pushes data into RabbitMQ as an event of the routing key, with the
+// request ID and job ID as headers. With an outbox, the event is written to it
+// and published by the relay.
+func (app *Config) pushToQueue(ctx context.Context, routingKey string, data any, jobID string) error {
+	j, _ := json.Marshal(data)
+
+	if app.Outbox != nil {
+		return app.outboxEvent(ctx, routingKey, j, map[string]string{
+			requestIDAMQPHeader: requestIDFromContext(ctx),
+			jobIDAMQPHeader:     jobID,
+		})
 	}
 
-	j, _ := json.MarshalIndent(&payload, "", "\t")
-	err = emitter.Push(string(j), payload.Severity)
+	headers := amqp.Table{
+		requestIDAMQPHeader: requestIDFromContext(ctx),
+		jobIDAMQPHeader:     jobID,
+	}
+
+	err := app.Emitter.Push(ctx, routingKey, j, headers)
 	if err != nil {
 		return err
 	}
@@ -258,69 +475,124 @@ func (app *Config) pushToQueue(payload RabbitPayload) error {
 	return nil
 }
 
-func (app *Config) rpcRequest(w http.ResponseWriter, addr string, serviceMethod string, rpcPayload any) {
-	client, err := rpc.Dial("tcp", addr)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
+// rpcRequest calls the route's method over net/rpc and returns its response. As
+// net/rpc has no headers, the trace context is sent in the payload.
+func (app *Config) rpcRequest(ctx context.Context, route Route, requestPayload RequestPayload) (jsonResponse, error) {
+	ctx, span := tracer.Start(ctx, route.Method, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	requestPayload.setTraceContext(traceCarrier(ctx))
+	rpcPayload := requestPayload.forService(route.Service)
 
 	var result []byte
-	err = client.Call(serviceMethod, rpcPayload, &result)
+	err := app.RPC.Call(route.Address, route.Method, rpcPayload, &result, route.timeout)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		span.RecordError(err)
+		span.SetStatus(otelcodes.Error, err.Error())
+
+		// errors returned by the remote method mean the service is up
+		var serverErr rpc.ServerError
+		if errors.As(err, &serverErr) {
+			return jsonResponse{}, err
+		}
+		return jsonResponse{}, callError(ctx, route.Service, err)
 	}
 
 	var payload jsonResponse
 	_ = json.Unmarshal(result, &payload)
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
 }
 
-func (app *Config) logViaGRPC(w http.ResponseWriter, requestPayload LogPayload) {
-	conn, err := grpc.Dial("logger-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+// grpcRequest calls one of the gRPC methods the broker has a client for
+func (app *Config) grpcRequest(ctx context.Context, route Route, requestPayload RequestPayload) (jsonResponse, error) {
+	conn, err := app.GRPC.Get(route.Address)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, callError(ctx, route.Service, err)
 	}
-	defer conn.Close()
 
-	c := logs.NewLogServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// the route's own timeout means the service is too slow, unlike the
+	// deadline of the client's request
+	request := ctx
+	ctx, cancel := context.WithTimeout(ctx, route.timeout)
 	defer cancel()
 
-	_, err = c.WriteLog(ctx, &logs.LogRequest{
-		LogEntry: &logs.Log{
-			Name: requestPayload.Name,
-			Data: requestPayload.Data,
-		},
-	})
-	if err != nil {
-		app.errorJSON(w, err)
-		return
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		requestIDMetadata, requestIDFromContext(ctx),
+		forwardedForMetadata, clientAddressFromContext(ctx),
+	)
+	if principal, ok := principalFromContext(ctx); ok && principal.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, authorizationMetadata, "Bearer "+principal.token)
 	}
 
 	var payload jsonResponse
+
+	switch route.Method {
+	case "/logs.LogService/WriteLog":
+		payload, err = logViaGRPC(ctx, logs.NewLogServiceClient(conn), requestPayload.Log)
+	case "/mails.MailService/SendMail":
+		payload, err = sendMailViaGRPC(ctx, mails.NewMailServiceClient(conn), requestPayload.Mail)
+	case "/auths.AuthService/Authenticate":
+		payload, err = authenticateViaGRPC(ctx, auths.NewAuthServiceClient(conn), requestPayload.Auth)
+	case "/auths.AuthService/CreateUser",
+		"/auths.AuthService/ListUsers",
+		"/auths.AuthService/GetUser",
+		"/auths.AuthService/UpdateUser",
+		"/auths.AuthService/DeleteUser",
+		"/auths.AuthService/SetPassword",
+		"/auths.AuthService/UnlockUser",
+		"/auths.AuthService/UnlockAddress":
+		payload, err = manageUserViaGRPC(ctx, auths.NewAuthServiceClient(conn), route.Method, requestPayload.User)
+	default:
+		return jsonResponse{}, fmt.Errorf("unsupported gRPC method %s", route.Method)
+	}
+	if err != nil {
+		return jsonResponse{}, grpcError(request, route.Service, err)
+	}
+
 	payload.Error = false
-	payload.Message = "Logged via gRPC"
+	payload.Message = route.Message
 
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return payload, nil
+}
+
+// grpcError tells apart the errors of a service that could not be reached from
+// the ones it returned, and from the end of the request of the client
+func grpcError(ctx context.Context, service string, err error) error {
+	st := status.Convert(err)
+
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Canceled:
+		return callError(ctx, service, err)
+	case codes.Unauthenticated:
+		return withStatus(http.StatusUnauthorized, errors.New(st.Message()))
+	case codes.PermissionDenied:
+		return withStatus(http.StatusForbidden, errors.New(st.Message()))
+	case codes.NotFound:
+		return withStatus(http.StatusNotFound, errors.New(st.Message()))
+	case codes.AlreadyExists:
+		return withStatus(http.StatusConflict, errors.New(st.Message()))
+	default:
+		return errors.New(st.Message())
+	}
 }
 
-func (app *Config) sendMailViaGRPC(w http.ResponseWriter, requestPayload MailPayload) {
-	conn, err := grpc.Dial("mailer-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
+func logViaGRPC(ctx context.Context, c logs.LogServiceClient, requestPayload LogPayload) (jsonResponse, error) {
+	_, err := c.WriteLog(ctx, &logs.LogRequest{
+		LogEntry: &logs.Log{
+			Name: requestPayload.Name,
+			Data: requestPayload.Data,
+		},
+	})
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
-	defer conn.Close()
 
-	c := mails.NewMailServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	return jsonResponse{}, nil
+}
 
-	_, err = c.SendMail(ctx, &mails.MailRequest{
+func sendMailViaGRPC(ctx context.Context, c mails.MailServiceClient, requestPayload MailPayload) (jsonResponse, error) {
+	_, err := c.SendMail(ctx, &mails.MailRequest{
 		MailEntry: &mails.Mail{
 			From:    requestPayload.From,
 			To:      requestPayload.To,
@@ -329,44 +601,34 @@ func (app *Config) sendMailViaGRPC(w http.ResponseWriter, requestPayload MailPay
 		},
 	})
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
-	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Mail sent via gRPC"
-
-	app.writeJSON(w, http.StatusAccepted, payload)
+	return jsonResponse{}, nil
 }
 
-func (app *Config) authenticateViaGRPC(w http.ResponseWriter, requestPayload AuthPayload) {
-	conn, err := grpc.Dial("authentication-service:50001", grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock())
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
-	defer conn.Close()
-
-	c := auths.NewAuthServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-
-	_, err = c.Authenticate(ctx, &auths.AuthRequest{
+func authenticateViaGRPC(ctx context.Context, c auths.AuthServiceClient, requestPayload AuthPayload) (jsonResponse, error) {
+	res, err := c.Authenticate(ctx, &auths.AuthRequest{
 		AuthEntry: &auths.Auth{
-			Name:    requestPayload.Name,
-			Email:      requestPayload.Email,
+			Name:     requestPayload.Name,
+			Email:    requestPayload.Email,
 			Password: requestPayload.Password,
 		},
 	})
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return jsonResponse{}, err
 	}
 
 	var payload jsonResponse
-	payload.Error = false
-	payload.Message = "Authenticated via gRPC"
+	payload.Data = map[string]TokenPayload{
+		"token": {
+			AccessToken:      res.AccessToken,
+			TokenType:        res.TokenType,
+			ExpiresAt:        time.Unix(res.ExpiresAt, 0),
+			RefreshToken:     res.RefreshToken,
+			RefreshExpiresAt: time.Unix(res.RefreshExpiresAt, 0),
+		},
+	}
 
-	app.writeJSON(w, http.StatusAccepted, payload)
-}
\ No newline at end of file
+	return payload, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServiceResponse(t *testing.T) {
+	auth := Route{Service: "authentication-service", Message: "Authenticated"}
+	mailer := Route{Service: "mailer-service", Message: "Message sent via JSON"}
+
+	tests := []struct {
+		name        string
+		route       Route
+		status      int
+		body        string
+		wantStatus  int
+		unavailable bool
+	}{
+		{name: "success", route: mailer, status: 202, body: `{"error": false, "data": {"id": 1}}`},
+		{name: "auth 401", route: auth, status: 401, body: `{"error": true, "message": "invalid credentials"}`, wantStatus: 401},
+		{name: "auth error body", route: auth, status: 202, body: `{"error": true, "message": "invalid credentials"}`, wantStatus: 401},
+		{name: "auth 403", route: auth, status: 403, body: `{"error": true, "message": "user is inactive"}`, wantStatus: 403},
+		{name: "lockout", route: auth, status: 429, body: `{"error": true}`, wantStatus: 429},
+		{name: "mailer error body", route: mailer, status: 202, body: `{"error": true, "message": "invalid to"}`, wantStatus: 400},
+		{name: "mailer 400", route: mailer, status: 400, body: `{"error": true, "message": "invalid to"}`, wantStatus: 400},
+		{name: "mailer 401", route: mailer, status: 401, body: ``, wantStatus: 502},
+		{name: "unexpected status", route: mailer, status: 200, body: `{}`, wantStatus: 502},
+		{name: "invalid body", route: mailer, status: 202, body: `<html>`, wantStatus: 502},
+		{name: "server error", route: mailer, status: 500, body: ``, unavailable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := serviceResponse(tt.route, tt.status, strings.NewReader(tt.body))
+
+			switch {
+			case tt.unavailable:
+				if !isUnavailable(err) {
+					t.Fatalf("err = %v, want the service unavailable", err)
+				}
+			case tt.wantStatus != 0:
+				if err == nil || statusCode(err) != tt.wantStatus {
+					t.Fatalf("err = %v, want status %d", err, tt.wantStatus)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if payload.Message != tt.route.Message {
+					t.Errorf("message = %q, want %q", payload.Message, tt.route.Message)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPRequestMailRecipient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"error": false, "message": "sent"}`))
+	}))
+	defer srv.Close()
+
+	route := Route{
+		Service: "mailer-service",
+		Address: strings.TrimPrefix(srv.URL, "http://"),
+		Method:  "/send",
+		Message: "Message sent via JSON",
+		timeout: time.Second,
+	}
+
+	var app Config
+
+	payload, err := app.httpRequest(context.Background(), route, MailPayload{To: "you@there.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Message sent via JSON to you@there.com"; payload.Message != want {
+		t.Errorf("message = %q, want %q", payload.Message, want)
+	}
+}
@@ -1,18 +1,75 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 type jsonResponse struct {
 	Error   bool   `json:"error"`
+	Code    string `json:"code,omitempty"`
+	Target  string `json:"target,omitempty"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
 
+// unavailableError is returned when the target of a request could not be reached
+type unavailableError struct {
+	Target string
+	Err    error
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable: %v", e.Target, e.Err)
+}
+
+func (e *unavailableError) Unwrap() error {
+	return e.Err
+}
+
+func unavailable(target string, err error) error {
+	return &unavailableError{Target: target, Err: err}
+}
+
+// callError is the error of a call to target that failed: the error of ctx
+// when the request of the client was cancelled or timed out, which says
+// nothing about target, and an unavailableError otherwise
+func callError(ctx context.Context, target string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return unavailable(target, err)
+}
+
+func isUnavailable(err error) bool {
+	var unavailableErr *unavailableError
+	return errors.As(err, &unavailableErr)
+}
+
+// statusError is an error that is sent back to the client with a specific status code
+type statusError struct {
+	Status int
+	Err    error
+}
+
+func (e *statusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.Err
+}
+
+func withStatus(status int, err error) error {
+	return &statusError{Status: status, Err: err}
+}
+
 // readJSON tries to read the body of a request and converts it into JSON
 func (app *Config) readJSON(w http.ResponseWriter, r *http.Request, data any) error {
 	maxBytes := 1048576 // one megabyte
@@ -57,17 +114,59 @@ func (app *Config) writeJSON(w http.ResponseWriter, status int, data any, header
 }
 
 // errorJSON takes an error, and optionally a response status code, and generates and sends
-// a json error response
+// a json error response. Errors of unavailable targets are sent as 503 with the name of
+// the target, so clients can tell them apart from bad requests.
 func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) error {
-	statusCode := http.StatusBadRequest
-
-	if len(status) > 0 {
-		statusCode = status[0]
-	}
+	code := statusCode(err)
 
 	var payload jsonResponse
 	payload.Error = true
 	payload.Message = err.Error()
 
-	return app.writeJSON(w, statusCode, payload)
+	var unavailableErr *unavailableError
+	if errors.As(err, &unavailableErr) {
+		payload.Target = unavailableErr.Target
+	}
+
+	if len(status) > 0 {
+		code = status[0]
+	}
+
+	payload.Code = errorCode(code)
+
+	return app.writeJSON(w, code, payload)
+}
+
+// statusCode is the response status code of err: 503 for unavailable targets, 504
+// for requests that timed out, the status of a statusError, and 400 for anything else
+func statusCode(err error) int {
+	var unavailableErr *unavailableError
+	var statusErr *statusError
+
+	switch {
+	case errors.As(err, &unavailableErr):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &statusErr):
+		return statusErr.Status
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// errorCode is the machine readable counterpart of an error status code
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusServiceUnavailable:
+		return "target_unavailable"
+	default:
+		return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
 }
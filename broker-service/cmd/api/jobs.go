@@ -0,0 +1,218 @@
+package main
+
+import (
+	"broker-service/event"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Statuses of a job. Succeeded and failed are final.
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobSucceeded  = "succeeded"
+	JobFailed     = "failed"
+)
+
+const (
+	// jobStatusKey is the routing key of the status events published by the listener
+	jobStatusKey = "job.STATUS"
+	// jobIDAMQPHeader carries the ID of the job of an event
+	jobIDAMQPHeader = "x-job-id"
+
+	jobTTL         = time.Hour
+	jobCleanupTime = time.Minute
+	// maxJobWait bounds the wait of long-polling clients
+	maxJobWait = time.Minute
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Job tracks the outcome of an event published for an action that doesn't wait
+// for a reply.
+type Job struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	UserID    int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Finished reports whether the job reached a final status.
+func (job Job) Finished() bool {
+	return job.Status == JobSucceeded || job.Status == JobFailed
+}
+
+// JobEvent is the status of a job, published by the listener as it handles the event.
+type JobEvent struct {
+	JobID  string `json:"job_id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// JobStore keeps track of the jobs started by the broker.
+type JobStore interface {
+	// Create adds a new job.
+	Create(ctx context.Context, job Job) error
+	// Get returns the job with the given ID, or ErrJobNotFound.
+	Get(ctx context.Context, id string) (Job, error)
+	// Update sets the status of a job. Finished jobs are not updated anymore.
+	Update(ctx context.Context, id, status, reason string) error
+	// Wait returns the job once it is finished, or as it is when ctx is done.
+	Wait(ctx context.Context, id string) (Job, error)
+}
+
+// newJobStore returns the job store selected by JOB_STORE. Only memory (the
+// default) is supported for now.
+func newJobStore() (JobStore, error) {
+	switch store := os.Getenv("JOB_STORE"); store {
+	case "", "memory":
+		return newMemoryJobStore(jobTTL), nil
+	default:
+		return nil, fmt.Errorf("unsupported JOB_STORE %s", store)
+	}
+}
+
+// memoryJobStore keeps the jobs in memory, forgetting them ttl after their last
+// update. Jobs are only known by the broker instance that started them, so it
+// suits a single instance, or clients that are routed back to the same one.
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*memoryJob
+	ttl  time.Duration
+}
+
+type memoryJob struct {
+	job Job
+	// done is closed when the job is finished
+	done chan struct{}
+}
+
+func newMemoryJobStore(ttl time.Duration) *memoryJobStore {
+	store := &memoryJobStore{
+		jobs: make(map[string]*memoryJob),
+		ttl:  ttl,
+	}
+
+	go store.cleanup()
+
+	return store
+}
+
+func (s *memoryJobStore) Create(ctx context.Context, job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobs[job.ID] = &memoryJob{
+		job:  job,
+		done: make(chan struct{}),
+	}
+
+	return nil
+}
+
+func (s *memoryJobStore) Get(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+
+	return entry.job, nil
+}
+
+func (s *memoryJobStore) Update(ctx context.Context, id, status, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.jobs[id]
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	if entry.job.Finished() {
+		return nil
+	}
+
+	entry.job.Status = status
+	entry.job.Reason = reason
+	entry.job.UpdatedAt = time.Now()
+
+	if entry.job.Finished() {
+		close(entry.done)
+	}
+
+	return nil
+}
+
+func (s *memoryJobStore) Wait(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	entry, ok := s.jobs[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+
+	select {
+	case <-entry.done:
+	case <-ctx.Done():
+	}
+
+	return s.Get(ctx, id)
+}
+
+// cleanup periodically removes the jobs that were not updated for ttl
+func (s *memoryJobStore) cleanup() {
+	ticker := time.NewTicker(jobCleanupTime)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		for id, entry := range s.jobs {
+			if time.Since(entry.job.UpdatedAt) > s.ttl {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// updateJob applies a status event published by the listener to its job. The
+// status is the data of a CloudEvent, or the whole body of older listeners.
+func (app *Config) updateJob(d amqp.Delivery) {
+	var evt JobEvent
+
+	body := d.Body
+	cloudEvent, ok, err := event.DecodeCloudEvent(d)
+	if err != nil {
+		log.Println("Error decoding job status:", err)
+		return
+	}
+	if ok {
+		body = cloudEvent.Data
+	}
+
+	err = json.Unmarshal(body, &evt)
+	if err != nil {
+		log.Println("Error decoding job status:", err)
+		return
+	}
+
+	err = app.Jobs.Update(context.Background(), evt.JobID, evt.Status, evt.Reason)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		log.Println("Error updating job", evt.JobID, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "broker",
+		Name:      "requests_total",
+		Help:      "Submissions handled by the broker, by action, transport and response status.",
+	}, []string{"action", "transport", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "broker",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle a submission, by action and transport.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action", "transport"})
+
+	downstreamErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "broker",
+		Name:      "downstream_errors_total",
+		Help:      "Failed calls to downstreams, by downstream, transport and reason (unavailable, circuit_open or error).",
+	}, []string{"downstream", "transport", "reason"})
+
+	outboxRelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "broker",
+		Name:      "outbox_relayed_total",
+		Help:      "Attempts to publish outbox entries, by result (sent, retried or failed).",
+	}, []string{"result"})
+)
+
+// observeRequest records a submission to route that took since start and ended with err
+func observeRequest(route Route, start time.Time, err error) {
+	status := http.StatusAccepted
+	if err != nil {
+		status = statusCode(err)
+	}
+
+	requestsTotal.WithLabelValues(route.Action, route.Transport, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route.Action, route.Transport).Observe(time.Since(start).Seconds())
+}
+
+// observeDownstreamError records a failed attempt to call the downstream of route
+func observeDownstreamError(route Route, err error) {
+	reason := "error"
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		reason = "circuit_open"
+	case isUnavailable(err):
+		reason = "unavailable"
+	}
+
+	downstreamErrors.WithLabelValues(route.downstream(), route.Transport, reason).Inc()
+}
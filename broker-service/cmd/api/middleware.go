@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	principalKey     contextKey = "principal"
+	requestIDKey     contextKey = "request-id"
+	clientAddressKey contextKey = "client-address"
+)
+
+// The correlation ID of a request is carried in these headers, gRPC metadata key
+// and AMQP message header.
+const (
+	requestIDHeader     = "X-Request-ID"
+	requestIDMetadata   = "x-request-id"
+	requestIDAMQPHeader = "x-request-id"
+)
+
+// authorizationMetadata carries the access token of the principal to the gRPC
+// services, like the Authorization header.
+const authorizationMetadata = "authorization"
+
+// forwardedForMetadata carries the address of the client to the gRPC services,
+// like the X-Forwarded-For header.
+const forwardedForMetadata = "x-forwarded-for"
+
+// statusAMQPHeader carries the status code answered by the service in the reply
+// to a request made over RabbitMQ.
+const statusAMQPHeader = "x-status"
+
+// requestID attaches a correlation ID to every request, reusing the one in the
+// X-Request-ID header when the client sent it, and echoes it in the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newUUID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientAddress attaches the address of the client to every request, passed
+// on to the services so they can tell clients apart, like for the lockouts of
+// the authentication service. The broker faces the clients, so the address
+// is the one of the connection: headers sent by clients can't be trusted.
+func clientAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ctx := context.WithValue(r.Context(), clientAddressKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientAddressFromContext returns the client address attached by clientAddress.
+func clientAddressFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientAddressKey).(string)
+	return ip
+}
+
+// requestIDFromContext returns the correlation ID attached by requestID.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// principalFromContext returns the principal attached to ctx by requireAuth, if any.
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey).(*Principal)
+	return principal, ok
+}
+
+// requireAuth guards the submission endpoint. Public actions (like logging in) are
+// let through; every other action needs a valid bearer token, and the principal
+// must have one of the roles the action's route allows.
+func (app *Config) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action, err := peekAction(w, r)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		route, found := app.Routes.Lookup(action)
+		if found && route.Public {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := app.authenticate(r)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		if found && !route.allows(principal) {
+			app.errorJSON(w, fmt.Errorf("action %s is not allowed", action), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireToken guards endpoints that only need a valid bearer token, whatever
+// the role of the principal.
+func (app *Config) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := app.authenticate(r)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), principalKey, principal)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin lets only admins through. It must follow requireToken.
+func (app *Config) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := principalFromContext(r.Context())
+		if !ok || principal.Role != roleAdmin {
+			app.errorJSON(w, errors.New("admin role required"), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the principal the bearer token of r was issued to
+func (app *Config) authenticate(r *http.Request) (*Principal, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	principal, err := app.Verifier.Verify(r.Context(), token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	principal.token = token
+
+	return principal, nil
+}
+
+// bearerToken extracts the token from the Authorization header
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", errors.New("missing authorization header")
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", errors.New("invalid authorization header")
+	}
+
+	return token, nil
+}
+
+// peekAction reads the action of a submission without consuming the request body
+func peekAction(w http.ResponseWriter, r *http.Request) (string, error) {
+	maxBytes := 1048576 // one megabyte
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var submission struct {
+		Action string `json:"action"`
+	}
+
+	err = json.Unmarshal(body, &submission)
+	if err != nil {
+		return "", err
+	}
+
+	return submission.Action, nil
+}
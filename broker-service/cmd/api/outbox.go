@@ -0,0 +1,226 @@
+package main
+
+import (
+	"broker-service/event"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Statuses of an outbox entry. Sent and failed are final.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+const (
+	// outboxInterval is the wait between two relays of the pending entries,
+	// which are also relayed as soon as one is added
+	outboxInterval = time.Second
+	// outboxBatch is the number of entries relayed at once
+	outboxBatch = 100
+	// outboxMaxAttempts is the number of failed publishings before an entry fails
+	outboxMaxAttempts = 10
+	// outboxRetryDelay is the wait after the first failed publishing, doubled
+	// after each failure up to outboxMaxRetryDelay
+	outboxRetryDelay    = time.Second
+	outboxMaxRetryDelay = 5 * time.Minute
+	// outboxRetention is how long sent entries are kept
+	outboxRetention = 7 * 24 * time.Hour
+)
+
+// OutboxEntry is an event written to the outbox before it is published.
+type OutboxEntry struct {
+	// ID is also the ID of the published CloudEvent, and its message ID
+	ID         string `json:"id"`
+	RoutingKey string `json:"routing_key"`
+	// Body is the JSON data of the event
+	Body      string            `json:"body"`
+	Headers   map[string]string `json:"headers"`
+	Status    string            `json:"status"`
+	Attempts  int               `json:"attempts"`
+	LastError string            `json:"last_error,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	// NextAttempt is when the entry is published again after a failure
+	NextAttempt time.Time `json:"next_attempt"`
+}
+
+// OutboxStore keeps the events of the broker until they are published.
+type OutboxStore interface {
+	// Add writes a new pending entry.
+	Add(ctx context.Context, entry OutboxEntry) error
+	// Due returns up to limit pending entries whose next attempt is due, oldest first.
+	Due(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// MarkSent records that the entry was published.
+	MarkSent(ctx context.Context, id string) error
+	// MarkFailed records a failed attempt to publish the entry, which is tried
+	// again at next, or fails for good if final is set.
+	MarkFailed(ctx context.Context, id, reason string, next time.Time, final bool) error
+	// List returns up to limit entries with one of the statuses, newest first.
+	List(ctx context.Context, statuses []string, limit int) ([]OutboxEntry, error)
+	// Purge removes the sent entries last updated before t.
+	Purge(ctx context.Context, t time.Time) error
+	// Close releases the store.
+	Close() error
+}
+
+// newOutboxStore returns the outbox store selected by OUTBOX_STORE: sqlite (the
+// default) or file, kept at OUTBOX_PATH. With none, events are pushed to
+// RabbitMQ right away instead.
+func newOutboxStore() (OutboxStore, error) {
+	path := os.Getenv("OUTBOX_PATH")
+
+	switch store := os.Getenv("OUTBOX_STORE"); store {
+	case "", "sqlite":
+		if path == "" {
+			path = "outbox.db"
+		}
+		return newSQLiteOutbox(path)
+	case "file":
+		if path == "" {
+			path = "outbox.log"
+		}
+		return newFileOutbox(path)
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported OUTBOX_STORE %s", store)
+	}
+}
+
+// outboxEvent writes an event to the outbox and wakes the relay up. The trace
+// context of ctx is kept with its headers, so the relay continues the trace.
+func (app *Config) outboxEvent(ctx context.Context, routingKey string, body []byte, headers map[string]string) error {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+
+	now := time.Now()
+	err := app.Outbox.Add(ctx, OutboxEntry{
+		ID:          newUUID(),
+		RoutingKey:  routingKey,
+		Body:        string(body),
+		Headers:     headers,
+		Status:      OutboxPending,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		NextAttempt: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case app.outboxReady <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// relayOutbox publishes the pending entries of the outbox until the process exits
+func (app *Config) relayOutbox() {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-app.outboxReady:
+		}
+
+		app.relayDue()
+
+		if time.Since(lastPurge) > time.Hour {
+			err := app.Outbox.Purge(context.Background(), time.Now().Add(-outboxRetention))
+			if err != nil {
+				log.Println("Error purging outbox:", err)
+			}
+			lastPurge = time.Now()
+		}
+	}
+}
+
+// relayDue publishes the entries that are due and marks them sent, or failed
+// once they were tried outboxMaxAttempts times. Relaying stops while RabbitMQ
+// is disconnected, without counting it as an attempt.
+func (app *Config) relayDue() {
+	ctx := context.Background()
+
+	entries, err := app.Outbox.Due(ctx, outboxBatch)
+	if err != nil {
+		log.Println("Error reading outbox:", err)
+		return
+	}
+
+	for _, entry := range entries {
+		err = app.relay(entry)
+		if event.IsDisconnected(err) {
+			return
+		}
+
+		if err == nil {
+			err = app.Outbox.MarkSent(ctx, entry.ID)
+			if err != nil {
+				log.Println("Error marking outbox entry sent:", entry.ID, err)
+			}
+			outboxRelayed.WithLabelValues("sent").Inc()
+			continue
+		}
+
+		final := entry.Attempts+1 >= outboxMaxAttempts
+		next := time.Now().Add(outboxBackoff(entry.Attempts))
+
+		log.Printf("Error publishing outbox entry %s (attempt %d): %v\n", entry.ID, entry.Attempts+1, err)
+
+		markErr := app.Outbox.MarkFailed(ctx, entry.ID, err.Error(), next, final)
+		if markErr != nil {
+			log.Println("Error marking outbox entry failed:", entry.ID, markErr)
+		}
+
+		if !final {
+			outboxRelayed.WithLabelValues("retried").Inc()
+			continue
+		}
+		outboxRelayed.WithLabelValues("failed").Inc()
+
+		// the job of the event won't be handled
+		if jobID := entry.Headers[jobIDAMQPHeader]; jobID != "" {
+			_ = app.Jobs.Update(ctx, jobID, JobFailed, err.Error())
+		}
+	}
+}
+
+// relay publishes an entry as a CloudEvent created when the entry was, and
+// waits for RabbitMQ to confirm it
+func (app *Config) relay(entry OutboxEntry) error {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(entry.Headers))
+
+	headers := amqp.Table{}
+	for key, value := range entry.Headers {
+		headers[key] = value
+	}
+
+	evt := event.NewCloudEvent(entry.ID, entry.RoutingKey, []byte(entry.Body), entry.CreatedAt)
+
+	return app.Emitter.Publish(ctx, evt, headers)
+}
+
+// outboxBackoff returns the wait before the next attempt to publish an entry
+// that failed attempts times before
+func outboxBackoff(attempts int) time.Duration {
+	delay := outboxRetryDelay << attempts
+	if delay <= 0 || delay > outboxMaxRetryDelay {
+		delay = outboxMaxRetryDelay
+	}
+
+	return delay
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errOutboxEntryNotFound = errors.New("outbox entry not found")
+
+// fileOutbox keeps the outbox in memory, backed by an append-only log of JSON
+// lines with the state of an entry after each change. The log is replayed and
+// compacted when the store is opened, and when sent entries are purged.
+type fileOutbox struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	entries map[string]*OutboxEntry
+}
+
+func newFileOutbox(path string) (*fileOutbox, error) {
+	s := &fileOutbox{
+		path:    path,
+		entries: make(map[string]*OutboxEntry),
+	}
+
+	err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay loads the latest state of every entry of the log
+func (s *fileOutbox) replay() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var entry OutboxEntry
+
+		// a line cut short by a crash is dropped
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+			continue
+		}
+
+		s.entries[entry.ID] = &entry
+	}
+
+	return scanner.Err()
+}
+
+// compact rewrites the log with one line per entry and reopens it for appending
+func (s *fileOutbox) compact() error {
+	tmp := s.path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for _, entry := range s.sorted(nil) {
+		line, _ := json.Marshal(entry)
+		_, _ = w.Write(append(line, '\n'))
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+
+	s.file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o644)
+
+	return err
+}
+
+// write appends the state of entry to the log and waits for it to reach the disk
+func (s *fileOutbox) write(entry *OutboxEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return s.file.Sync()
+}
+
+// sorted returns the entries accepted by keep (every entry if nil), oldest first
+func (s *fileOutbox) sorted(keep func(*OutboxEntry) bool) []OutboxEntry {
+	var entries []OutboxEntry
+	for _, entry := range s.entries {
+		if keep == nil || keep(entry) {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries
+}
+
+func (s *fileOutbox) Add(ctx context.Context, entry OutboxEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.write(&entry)
+	if err != nil {
+		return err
+	}
+
+	s.entries[entry.ID] = &entry
+
+	return nil
+}
+
+func (s *fileOutbox) Due(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := s.sorted(func(entry *OutboxEntry) bool {
+		return entry.Status == OutboxPending && !entry.NextAttempt.After(now)
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
+
+func (s *fileOutbox) MarkSent(ctx context.Context, id string) error {
+	return s.update(id, func(entry *OutboxEntry) {
+		entry.Status = OutboxSent
+		entry.LastError = ""
+	})
+}
+
+func (s *fileOutbox) MarkFailed(ctx context.Context, id, reason string, next time.Time, final bool) error {
+	return s.update(id, func(entry *OutboxEntry) {
+		if final {
+			entry.Status = OutboxFailed
+		}
+		entry.Attempts++
+		entry.LastError = reason
+		entry.NextAttempt = next
+	})
+}
+
+// update applies change to a copy of the entry, which replaces it once logged
+func (s *fileOutbox) update(id string, change func(*OutboxEntry)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.entries[id]
+	if !ok {
+		return errOutboxEntryNotFound
+	}
+
+	entry := *current
+	change(&entry)
+	entry.UpdatedAt = time.Now()
+
+	err := s.write(&entry)
+	if err != nil {
+		return err
+	}
+
+	s.entries[id] = &entry
+
+	return nil
+}
+
+func (s *fileOutbox) List(ctx context.Context, statuses []string, limit int) ([]OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := s.sorted(func(entry *OutboxEntry) bool {
+		for _, status := range statuses {
+			if entry.Status == status {
+				return true
+			}
+		}
+		return false
+	})
+
+	// newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
+
+func (s *fileOutbox) Purge(ctx context.Context, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := false
+	for id, entry := range s.entries {
+		if entry.Status == OutboxSent && entry.UpdatedAt.Before(t) {
+			delete(s.entries, id)
+			purged = true
+		}
+	}
+
+	if !purged {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *fileOutbox) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
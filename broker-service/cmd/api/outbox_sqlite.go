@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const outboxSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id           TEXT PRIMARY KEY,
+	routing_key  TEXT NOT NULL,
+	body         TEXT NOT NULL,
+	headers      TEXT NOT NULL,
+	status       TEXT NOT NULL,
+	attempts     INTEGER NOT NULL DEFAULT 0,
+	last_error   TEXT NOT NULL DEFAULT '',
+	created_at   INTEGER NOT NULL,
+	updated_at   INTEGER NOT NULL,
+	next_attempt INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_due ON outbox (status, next_attempt);
+`
+
+const outboxColumns = `id, routing_key, body, headers, status, attempts, last_error, created_at, updated_at, next_attempt`
+
+// sqliteOutbox keeps the outbox in a SQLite database. Times are stored as Unix
+// nanoseconds.
+type sqliteOutbox struct {
+	db *sql.DB
+}
+
+func newSQLiteOutbox(path string) (*sqliteOutbox, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`PRAGMA journal_mode = WAL; PRAGMA synchronous = FULL;` + outboxSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteOutbox{db: db}, nil
+}
+
+func (s *sqliteOutbox) Add(ctx context.Context, entry OutboxEntry) error {
+	headers, err := json.Marshal(entry.Headers)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into outbox (` + outboxColumns + `) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = s.db.ExecContext(ctx, stmt,
+		entry.ID,
+		entry.RoutingKey,
+		entry.Body,
+		string(headers),
+		entry.Status,
+		entry.Attempts,
+		entry.LastError,
+		entry.CreatedAt.UnixNano(),
+		entry.UpdatedAt.UnixNano(),
+		entry.NextAttempt.UnixNano(),
+	)
+
+	return err
+}
+
+func (s *sqliteOutbox) Due(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	query := `select ` + outboxColumns + ` from outbox
+	where status = ? and next_attempt <= ? order by created_at limit ?`
+
+	return s.query(ctx, query, OutboxPending, time.Now().UnixNano(), limit)
+}
+
+func (s *sqliteOutbox) MarkSent(ctx context.Context, id string) error {
+	stmt := `update outbox set status = ?, last_error = '', updated_at = ? where id = ?`
+
+	_, err := s.db.ExecContext(ctx, stmt, OutboxSent, time.Now().UnixNano(), id)
+
+	return err
+}
+
+func (s *sqliteOutbox) MarkFailed(ctx context.Context, id, reason string, next time.Time, final bool) error {
+	status := OutboxPending
+	if final {
+		status = OutboxFailed
+	}
+
+	stmt := `update outbox set status = ?, attempts = attempts + 1, last_error = ?, updated_at = ?, next_attempt = ?
+	where id = ?`
+
+	_, err := s.db.ExecContext(ctx, stmt, status, reason, time.Now().UnixNano(), next.UnixNano(), id)
+
+	return err
+}
+
+func (s *sqliteOutbox) List(ctx context.Context, statuses []string, limit int) ([]OutboxEntry, error) {
+	args := make([]any, 0, len(statuses)+1)
+	for _, status := range statuses {
+		args = append(args, status)
+	}
+	args = append(args, limit)
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ")
+	query := `select ` + outboxColumns + ` from outbox
+	where status in (` + placeholders + `) order by created_at desc limit ?`
+
+	return s.query(ctx, query, args...)
+}
+
+func (s *sqliteOutbox) Purge(ctx context.Context, t time.Time) error {
+	stmt := `delete from outbox where status = ? and updated_at < ?`
+
+	_, err := s.db.ExecContext(ctx, stmt, OutboxSent, t.UnixNano())
+
+	return err
+}
+
+func (s *sqliteOutbox) Close() error {
+	return s.db.Close()
+}
+
+// query returns the entries selected by query
+func (s *sqliteOutbox) query(ctx context.Context, query string, args ...any) ([]OutboxEntry, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []OutboxEntry
+
+	for rows.Next() {
+		var entry OutboxEntry
+		var headers string
+		var createdAt, updatedAt, nextAttempt int64
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.RoutingKey,
+			&entry.Body,
+			&headers,
+			&entry.Status,
+			&entry.Attempts,
+			&entry.LastError,
+			&createdAt,
+			&updatedAt,
+			&nextAttempt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		_ = json.Unmarshal([]byte(headers), &entry.Headers)
+		entry.CreatedAt = time.Unix(0, createdAt)
+		entry.UpdatedAt = time.Unix(0, updatedAt)
+		entry.NextAttempt = time.Unix(0, nextAttempt)
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// outboxStores opens each outbox store in a new directory
+var outboxStores = []struct {
+	name string
+	open func(path string) (OutboxStore, error)
+	file string
+}{
+	{name: "sqlite", open: func(path string) (OutboxStore, error) { return newSQLiteOutbox(path) }, file: "outbox.db"},
+	{name: "file", open: func(path string) (OutboxStore, error) { return newFileOutbox(path) }, file: "outbox.log"},
+}
+
+// outboxEntry returns a pending entry created at created
+func outboxEntry(id string, created time.Time) OutboxEntry {
+	return OutboxEntry{
+		ID:          id,
+		RoutingKey:  "log.INFO",
+		Body:        `{"name":"event","data":"` + id + `"}`,
+		Headers:     map[string]string{"x-request-id": "request-" + id},
+		Status:      OutboxPending,
+		CreatedAt:   created,
+		UpdatedAt:   created,
+		NextAttempt: created,
+	}
+}
+
+// entryIDs returns the IDs of entries, in order
+func entryIDs(entries []OutboxEntry) []string {
+	ids := []string{}
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+
+	return ids
+}
+
+func TestOutboxStores(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// change is applied to a store holding the entries a, b and c, created in that order
+		change   func(t *testing.T, store OutboxStore)
+		due      []string
+		pending  []string
+		sent     []string
+		failed   []string
+		attempts map[string]int
+	}{
+		{
+			name:    "added entries are due oldest first",
+			change:  func(t *testing.T, store OutboxStore) {},
+			due:     []string{"a", "b", "c"},
+			pending: []string{"c", "b", "a"},
+		},
+		{
+			name: "sent entries are no longer due",
+			change: func(t *testing.T, store OutboxStore) {
+				mustOutbox(t, store.MarkSent(ctx, "b"))
+			},
+			due:     []string{"a", "c"},
+			pending: []string{"c", "a"},
+			sent:    []string{"b"},
+		},
+		{
+			name: "failed attempts are due again at their next attempt",
+			change: func(t *testing.T, store OutboxStore) {
+				mustOutbox(t, store.MarkFailed(ctx, "a", "unroutable", now.Add(time.Hour), false))
+				mustOutbox(t, store.MarkFailed(ctx, "c", "unroutable", now.Add(-time.Second), false))
+				mustOutbox(t, store.MarkFailed(ctx, "c", "unroutable", now.Add(-time.Second), false))
+			},
+			due:      []string{"b", "c"},
+			pending:  []string{"c", "b", "a"},
+			attempts: map[string]int{"a": 1, "c": 2},
+		},
+		{
+			name: "final failures are never due",
+			change: func(t *testing.T, store OutboxStore) {
+				mustOutbox(t, store.MarkFailed(ctx, "a", "nacked", now, true))
+			},
+			due:      []string{"b", "c"},
+			pending:  []string{"c", "b"},
+			failed:   []string{"a"},
+			attempts: map[string]int{"a": 1},
+		},
+		{
+			name: "purge removes the sent entries updated before the time",
+			change: func(t *testing.T, store OutboxStore) {
+				mustOutbox(t, store.MarkSent(ctx, "a"))
+				mustOutbox(t, store.MarkSent(ctx, "b"))
+				mustOutbox(t, store.MarkFailed(ctx, "c", "nacked", now, true))
+				mustOutbox(t, store.Purge(ctx, time.Now().Add(time.Minute)))
+			},
+			due:      []string{},
+			failed:   []string{"c"},
+			sent:     []string{},
+			attempts: map[string]int{"c": 1},
+		},
+		{
+			name: "purge keeps the sent entries updated since the time",
+			change: func(t *testing.T, store OutboxStore) {
+				mustOutbox(t, store.MarkSent(ctx, "a"))
+				mustOutbox(t, store.Purge(ctx, now.Add(-time.Minute)))
+			},
+			due:     []string{"b", "c"},
+			pending: []string{"c", "b"},
+			sent:    []string{"a"},
+		},
+	}
+
+	for _, s := range outboxStores {
+		for _, tt := range tests {
+			t.Run(s.name+"/"+tt.name, func(t *testing.T) {
+				path := filepath.Join(t.TempDir(), s.file)
+
+				store, err := s.open(path)
+				mustOutbox(t, err)
+
+				for i, id := range []string{"a", "b", "c"} {
+					mustOutbox(t, store.Add(ctx, outboxEntry(id, now.Add(time.Duration(i-3)*time.Second))))
+				}
+
+				tt.change(t, store)
+
+				// the state must be the same once the store is opened again
+				for _, reopened := range []bool{false, true} {
+					if reopened {
+						mustOutbox(t, store.Close())
+						store, err = s.open(path)
+						mustOutbox(t, err)
+					}
+
+					checkOutboxIDs(t, "due", tt.due, func() ([]OutboxEntry, error) { return store.Due(ctx, 10) })
+					checkOutboxIDs(t, "pending", tt.pending, func() ([]OutboxEntry, error) { return store.List(ctx, []string{OutboxPending}, 10) })
+					checkOutboxIDs(t, "sent", tt.sent, func() ([]OutboxEntry, error) { return store.List(ctx, []string{OutboxSent}, 10) })
+					checkOutboxIDs(t, "failed", tt.failed, func() ([]OutboxEntry, error) { return store.List(ctx, []string{OutboxFailed}, 10) })
+
+					entries, err := store.List(ctx, []string{OutboxPending, OutboxSent, OutboxFailed}, 10)
+					mustOutbox(t, err)
+					for _, entry := range entries {
+						if entry.Attempts != tt.attempts[entry.ID] {
+							t.Errorf("entry %s has %d attempts, want %d", entry.ID, entry.Attempts, tt.attempts[entry.ID])
+						}
+					}
+				}
+
+				mustOutbox(t, store.Close())
+			})
+		}
+	}
+}
+
+func TestOutboxStoresKeepEntries(t *testing.T) {
+	ctx := context.Background()
+	created := time.Unix(0, time.Now().Add(-time.Minute).UnixNano())
+	next := created.Add(time.Hour)
+
+	for _, s := range outboxStores {
+		t.Run(s.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), s.file)
+
+			store, err := s.open(path)
+			mustOutbox(t, err)
+
+			want := outboxEntry("a", created)
+			mustOutbox(t, store.Add(ctx, want))
+			mustOutbox(t, store.MarkFailed(ctx, "a", "not connected", next, false))
+			mustOutbox(t, store.Close())
+
+			store, err = s.open(path)
+			mustOutbox(t, err)
+			defer store.Close()
+
+			entries, err := store.List(ctx, []string{OutboxPending}, 1)
+			mustOutbox(t, err)
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+
+			got := entries[0]
+			if got.ID != want.ID || got.RoutingKey != want.RoutingKey || got.Body != want.Body {
+				t.Errorf("got entry %+v, want %+v", got, want)
+			}
+			if !reflect.DeepEqual(got.Headers, want.Headers) {
+				t.Errorf("got headers %v, want %v", got.Headers, want.Headers)
+			}
+			if got.LastError != "not connected" || got.Attempts != 1 {
+				t.Errorf("got attempts %d and error %q, want 1 and %q", got.Attempts, got.LastError, "not connected")
+			}
+			if !got.CreatedAt.Equal(created) || !got.NextAttempt.Equal(next) {
+				t.Errorf("got created %s and next %s, want %s and %s", got.CreatedAt, got.NextAttempt, created, next)
+			}
+			if !got.UpdatedAt.After(created) {
+				t.Errorf("updated at %s, not after the creation at %s", got.UpdatedAt, created)
+			}
+		})
+	}
+}
+
+func TestOutboxStoresLimit(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	for _, s := range outboxStores {
+		t.Run(s.name, func(t *testing.T) {
+			store, err := s.open(filepath.Join(t.TempDir(), s.file))
+			mustOutbox(t, err)
+			defer store.Close()
+
+			for i, id := range []string{"a", "b", "c", "d"} {
+				mustOutbox(t, store.Add(ctx, outboxEntry(id, now.Add(time.Duration(i-4)*time.Second))))
+			}
+
+			checkOutboxIDs(t, "due", []string{"a", "b"}, func() ([]OutboxEntry, error) { return store.Due(ctx, 2) })
+			checkOutboxIDs(t, "listed", []string{"d", "c", "b"}, func() ([]OutboxEntry, error) { return store.List(ctx, []string{OutboxPending}, 3) })
+		})
+	}
+}
+
+func TestFileOutboxDropsCutLines(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "outbox.log")
+
+	store, err := newFileOutbox(path)
+	mustOutbox(t, err)
+	mustOutbox(t, store.Add(ctx, outboxEntry("a", time.Now())))
+	mustOutbox(t, store.Close())
+
+	// a crash while appending leaves half a line
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	mustOutbox(t, err)
+	_, err = file.WriteString(`{"id":"b","routing_key":"log.IN`)
+	mustOutbox(t, err)
+	mustOutbox(t, file.Close())
+
+	store, err = newFileOutbox(path)
+	mustOutbox(t, err)
+	defer store.Close()
+
+	checkOutboxIDs(t, "due", []string{"a"}, func() ([]OutboxEntry, error) { return store.Due(ctx, 10) })
+
+	// the log is compacted without the cut line, so new entries start on their own line
+	mustOutbox(t, store.Add(ctx, outboxEntry("c", time.Now())))
+	mustOutbox(t, store.Close())
+
+	store, err = newFileOutbox(path)
+	mustOutbox(t, err)
+	checkOutboxIDs(t, "due", []string{"a", "c"}, func() ([]OutboxEntry, error) { return store.Due(ctx, 10) })
+}
+
+func checkOutboxIDs(t *testing.T, name string, want []string, list func() ([]OutboxEntry, error)) {
+	t.Helper()
+
+	if want == nil {
+		want = []string{}
+	}
+
+	entries, err := list()
+	mustOutbox(t, err)
+
+	if got := entryIDs(entries); !reflect.DeepEqual(got, want) {
+		t.Errorf("%s entries = %v, want %v", name, got, want)
+	}
+}
+
+func mustOutbox(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
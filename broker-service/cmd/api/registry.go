@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultTimeout = time.Second
+
+// Route describes how the broker reaches the service behind one action.
+type Route struct {
+	Action     string   `json:"action" yaml:"action"`
+	Service    string   `json:"service" yaml:"service"`
+	Transport  string   `json:"transport" yaml:"transport"`
+	Address    string   `json:"address" yaml:"address"`
+	Method     string   `json:"method" yaml:"method"`
+	Timeout    string   `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Message    string   `json:"message,omitempty" yaml:"message,omitempty"`
+	Public     bool     `json:"public,omitempty" yaml:"public,omitempty"`
+	Roles      []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Idempotent bool     `json:"idempotent,omitempty" yaml:"idempotent,omitempty"`
+	Retries    int      `json:"retries,omitempty" yaml:"retries,omitempty"`
+	Reply      bool     `json:"reply,omitempty" yaml:"reply,omitempty"`
+
+	timeout time.Duration
+}
+
+// routeFile is the layout of the file the registry is loaded from
+type routeFile struct {
+	Breaker BreakerSettings `json:"breaker" yaml:"breaker"`
+	Routes  []Route         `json:"routes" yaml:"routes"`
+}
+
+// Registry maps action names to routes. It is safe for concurrent use, so it
+// can be reloaded while requests are being served.
+type Registry struct {
+	path    string
+	mu      sync.RWMutex
+	routes  map[string]Route
+	breaker BreakerSettings
+}
+
+// NewRegistry creates a registry and loads the routes in the file at path.
+func NewRegistry(path string) (*Registry, error) {
+	reg := &Registry{
+		path: path,
+	}
+
+	err := reg.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	return reg, nil
+}
+
+// Lookup returns the route registered for action.
+func (reg *Registry) Lookup(action string) (Route, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	route, ok := reg.routes[action]
+	return route, ok
+}
+
+// BreakerSettings returns the circuit breaker settings of the registry file.
+func (reg *Registry) BreakerSettings() BreakerSettings {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return reg.breaker
+}
+
+// Load reads the registry file again and replaces the current routes. If the
+// file is invalid the current routes are kept.
+func (reg *Registry) Load() error {
+	routes, breaker, err := readRoutes(reg.path)
+	if err != nil {
+		return err
+	}
+
+	reg.mu.Lock()
+	reg.routes = routes
+	reg.breaker = breaker
+	reg.mu.Unlock()
+
+	log.Printf("Loaded %d routes from %s\n", len(routes), reg.path)
+
+	return nil
+}
+
+// readRoutes parses a YAML or JSON route file, chosen by its extension.
+func readRoutes(path string) (map[string]Route, BreakerSettings, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, BreakerSettings{}, err
+	}
+
+	var file routeFile
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(content, &file)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &file)
+	default:
+		err = fmt.Errorf("unsupported route file %s", path)
+	}
+	if err != nil {
+		return nil, BreakerSettings{}, err
+	}
+
+	err = file.Breaker.validate()
+	if err != nil {
+		return nil, BreakerSettings{}, err
+	}
+
+	routes := make(map[string]Route, len(file.Routes))
+
+	for _, route := range file.Routes {
+		err := route.validate()
+		if err != nil {
+			return nil, BreakerSettings{}, err
+		}
+
+		if _, exists := routes[route.Action]; exists {
+			return nil, BreakerSettings{}, fmt.Errorf("duplicate route for action %s", route.Action)
+		}
+
+		routes[route.Action] = route
+	}
+
+	return routes, file.Breaker, nil
+}
+
+// validate checks the route and parses its timeout
+func (route *Route) validate() error {
+	if route.Action == "" {
+		return errors.New("route without action")
+	}
+
+	switch route.Transport {
+	case "http", "rpc", "grpc":
+		if route.Address == "" {
+			return fmt.Errorf("route %s: missing address", route.Action)
+		}
+	case "amqp":
+		// events are always published to the broker's exchange
+	default:
+		return fmt.Errorf("route %s: unknown transport %q", route.Action, route.Transport)
+	}
+
+	if route.Method == "" {
+		return fmt.Errorf("route %s: missing method", route.Action)
+	}
+
+	if route.Reply && route.Transport != "amqp" {
+		return fmt.Errorf("route %s: reply is only supported by amqp", route.Action)
+	}
+
+	if route.Retries < 0 {
+		return fmt.Errorf("route %s: negative retries", route.Action)
+	}
+
+	route.timeout = defaultTimeout
+	if route.Timeout != "" {
+		timeout, err := time.ParseDuration(route.Timeout)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route.Action, err)
+		}
+		route.timeout = timeout
+	}
+
+	return nil
+}
+
+// downstream names what the route depends on, which is what its circuit breaker
+// tracks: the service itself, or RabbitMQ for asynchronous actions.
+func (route Route) downstream() string {
+	if route.Transport == "amqp" {
+		return "rabbitmq"
+	}
+
+	return route.Service
+}
+
+// allows reports whether principal may perform the route's action. Routes without
+// roles are open to every authenticated principal.
+func (route Route) allows(principal *Principal) bool {
+	if len(route.Roles) == 0 {
+		return true
+	}
+
+	for _, role := range route.Roles {
+		if role == principal.Role {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRouteFile writes content to a route file named name in a temporary directory
+func writeRouteFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+		check   func(t *testing.T, routes map[string]Route, breaker BreakerSettings)
+	}{
+		{
+			name: "yaml with defaults",
+			file: "actions.yml",
+			content: `
+routes:
+  - action: log-json
+    service: logger-service
+    transport: http
+    address: logger-service
+    method: /log
+`,
+			check: func(t *testing.T, routes map[string]Route, breaker BreakerSettings) {
+				route := routes["log-json"]
+				if route.timeout != defaultTimeout {
+					t.Errorf("timeout = %s, want %s", route.timeout, defaultTimeout)
+				}
+				if breaker.FailureThreshold != defaultBreakerSettings.FailureThreshold ||
+					breaker.HalfOpenRequests != defaultBreakerSettings.HalfOpenRequests ||
+					breaker.openTimeout != defaultBreakerSettings.openTimeout {
+					t.Errorf("breaker = %+v, want the defaults", breaker)
+				}
+			},
+		},
+		{
+			name: "json with timeout and breaker",
+			file: "actions.json",
+			content: `{
+				"breaker": {"failure_threshold": 2, "open_timeout": "5s", "half_open_requests": 3},
+				"routes": [{"action": "auth-grpc", "service": "authentication-service", "transport": "grpc",
+					"address": "authentication-service:50001", "method": "/auths.AuthService/Authenticate",
+					"timeout": "10s", "public": true}]
+			}`,
+			check: func(t *testing.T, routes map[string]Route, breaker BreakerSettings) {
+				route := routes["auth-grpc"]
+				if route.timeout != 10*time.Second || !route.Public {
+					t.Errorf("route = %+v, want a public route with a 10s timeout", route)
+				}
+				if breaker.FailureThreshold != 2 || breaker.HalfOpenRequests != 3 || breaker.openTimeout != 5*time.Second {
+					t.Errorf("breaker = %+v", breaker)
+				}
+			},
+		},
+		{
+			name: "amqp without address",
+			file: "actions.yml",
+			content: `
+routes:
+  - action: log-rabbit
+    service: logger-service
+    transport: amqp
+    method: log.INFO
+`,
+			check: func(t *testing.T, routes map[string]Route, breaker BreakerSettings) {
+				if routes["log-rabbit"].downstream() != "rabbitmq" {
+					t.Errorf("downstream = %s, want rabbitmq", routes["log-rabbit"].downstream())
+				}
+			},
+		},
+		{
+			name:    "unsupported extension",
+			file:    "actions.toml",
+			content: ``,
+			wantErr: "unsupported route file",
+		},
+		{
+			name: "duplicate action",
+			file: "actions.yml",
+			content: `
+routes:
+  - {action: a, service: s, transport: http, address: s, method: /a}
+  - {action: a, service: s, transport: http, address: s, method: /b}
+`,
+			wantErr: "duplicate route for action a",
+		},
+		{
+			name:    "missing action",
+			file:    "actions.yml",
+			content: "routes:\n  - {service: s, transport: http, address: s, method: /a}\n",
+			wantErr: "route without action",
+		},
+		{
+			name:    "unknown transport",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: smtp, address: s, method: /a}\n",
+			wantErr: `unknown transport "smtp"`,
+		},
+		{
+			name:    "missing address",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: grpc, method: /a}\n",
+			wantErr: "missing address",
+		},
+		{
+			name:    "missing method",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: http, address: s}\n",
+			wantErr: "missing method",
+		},
+		{
+			name:    "reply over http",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: http, address: s, method: /a, reply: true}\n",
+			wantErr: "reply is only supported by amqp",
+		},
+		{
+			name:    "negative retries",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: http, address: s, method: /a, retries: -1}\n",
+			wantErr: "negative retries",
+		},
+		{
+			name:    "invalid timeout",
+			file:    "actions.yml",
+			content: "routes:\n  - {action: a, service: s, transport: http, address: s, method: /a, timeout: soon}\n",
+			wantErr: "route a",
+		},
+		{
+			name:    "invalid breaker timeout",
+			file:    "actions.yml",
+			content: "breaker:\n  open_timeout: later\nroutes: []\n",
+			wantErr: "later",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, breaker, err := readRoutes(writeRouteFile(t, tt.file, tt.content))
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, routes, breaker)
+		})
+	}
+}
+
+func TestRegistryLoadKeepsRoutesOfInvalidFile(t *testing.T) {
+	path := writeRouteFile(t, "actions.yml", "routes:\n  - {action: a, service: s, transport: http, address: s, method: /a}\n")
+
+	reg, err := NewRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, []byte("routes:\n  - {action: b, transport: http}\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reg.Load() == nil {
+		t.Fatal("Load accepted an invalid file")
+	}
+
+	if _, ok := reg.Lookup("a"); !ok {
+		t.Error("the routes of the last valid file were dropped")
+	}
+	if _, ok := reg.Lookup("b"); ok {
+		t.Error("a route of the invalid file was loaded")
+	}
+}
+
+func TestRouteAllows(t *testing.T) {
+	tests := []struct {
+		roles []string
+		role  string
+		want  bool
+	}{
+		{roles: nil, role: "user", want: true},
+		{roles: []string{"admin"}, role: "admin", want: true},
+		{roles: []string{"admin"}, role: "user", want: false},
+		{roles: []string{"user", "admin"}, role: "user", want: true},
+	}
+
+	for _, tt := range tests {
+		route := Route{Roles: tt.roles}
+		if got := route.allows(&Principal{Role: tt.role}); got != tt.want {
+			t.Errorf("roles %v allow %s = %v, want %v", tt.roles, tt.role, got, tt.want)
+		}
+	}
+}
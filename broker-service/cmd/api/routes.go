@@ -6,17 +6,22 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func (app *Config) routes() http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(requestID)
+	mux.Use(clientAddress)
+
 	// specify who is allowed to connect
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Request-ID"},
+		ExposedHeaders:   []string{"Link", "X-Request-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -25,7 +30,25 @@ func (app *Config) routes() http.Handler {
 
 	mux.Post("/", app.Broker)
 
-	mux.Post("/handle", app.HandleSubmission)
+	mux.With(app.requireAuth).Post("/handle", app.HandleSubmission)
+
+	mux.With(app.requireToken).Get("/jobs/{id}", app.GetJob)
+
+	mux.With(app.requireToken, app.requireAdmin).Get("/outbox", app.ListOutbox)
+
+	return otelhttp.NewHandler(mux, serviceName, otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// metricsRoutes serves the Prometheus metrics of the broker, apart from the
+// public routes
+func metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
 
 	return mux
 }
+
+// spanName names the span of a request after its method and path
+func spanName(operation string, r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"broker-service/auths"
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// UserPayload is a user managed through the authentication service. ID selects
+// the user of every method but CreateUser and ListUsers, and Address the
+// client address of UnlockAddress.
+type UserPayload struct {
+	ID        int64  `json:"id,omitempty"`
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Password  string `json:"password,omitempty"`
+	Active    *int32 `json:"active,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Address   string `json:"address,omitempty"`
+}
+
+// UserData is a user answered by the authentication service
+type UserData struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name,omitempty"`
+	LastName  string    `json:"last_name,omitempty"`
+	Active    int32     `json:"active"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func userData(user *auths.User) UserData {
+	return UserData{
+		ID:        user.Id,
+		Email:     user.Email,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Active:    user.Active,
+		Role:      user.Role,
+		CreatedAt: time.Unix(user.CreatedAt, 0),
+		UpdatedAt: time.Unix(user.UpdatedAt, 0),
+	}
+}
+
+// manageUserViaGRPC calls one of the user methods of the authentication
+// service, which only admins are allowed to call
+func manageUserViaGRPC(ctx context.Context, c auths.AuthServiceClient, method string, requestPayload UserPayload) (jsonResponse, error) {
+	input := &auths.UserInput{
+		Email:     requestPayload.Email,
+		FirstName: requestPayload.FirstName,
+		LastName:  requestPayload.LastName,
+		Password:  requestPayload.Password,
+		Role:      requestPayload.Role,
+	}
+	if requestPayload.Active != nil {
+		input.Active = wrapperspb.Int32(*requestPayload.Active)
+	}
+
+	var res *auths.UserResponse
+	var err error
+
+	switch method {
+	case "/auths.AuthService/CreateUser":
+		res, err = c.CreateUser(ctx, &auths.CreateUserRequest{User: input})
+	case "/auths.AuthService/ListUsers":
+		return listUsersViaGRPC(ctx, c)
+	case "/auths.AuthService/UnlockAddress":
+		return unlockAddressViaGRPC(ctx, c, requestPayload.Address)
+	case "/auths.AuthService/GetUser":
+		res, err = c.GetUser(ctx, &auths.UserRequest{Id: requestPayload.ID})
+	case "/auths.AuthService/UpdateUser":
+		res, err = c.UpdateUser(ctx, &auths.UpdateUserRequest{Id: requestPayload.ID, User: input})
+	case "/auths.AuthService/DeleteUser":
+		res, err = c.DeleteUser(ctx, &auths.UserRequest{Id: requestPayload.ID})
+	case "/auths.AuthService/SetPassword":
+		res, err = c.SetPassword(ctx, &auths.PasswordRequest{Id: requestPayload.ID, Password: requestPayload.Password})
+	case "/auths.AuthService/UnlockUser":
+		res, err = c.UnlockUser(ctx, &auths.UserRequest{Id: requestPayload.ID})
+	default:
+		return jsonResponse{}, fmt.Errorf("unsupported gRPC method %s", method)
+	}
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	var payload jsonResponse
+	payload.Data = userData(res.GetUser())
+
+	return payload, nil
+}
+
+func listUsersViaGRPC(ctx context.Context, c auths.AuthServiceClient) (jsonResponse, error) {
+	res, err := c.ListUsers(ctx, &auths.ListUsersRequest{})
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	users := make([]UserData, 0, len(res.GetUsers()))
+	for _, user := range res.GetUsers() {
+		users = append(users, userData(user))
+	}
+
+	var payload jsonResponse
+	payload.Data = users
+
+	return payload, nil
+}
+
+func unlockAddressViaGRPC(ctx context.Context, c auths.AuthServiceClient, address string) (jsonResponse, error) {
+	res, err := c.UnlockAddress(ctx, &auths.AddressRequest{Address: address})
+	if err != nil {
+		return jsonResponse{}, err
+	}
+
+	var payload jsonResponse
+	payload.Data = map[string]string{"address": res.GetAddress()}
+
+	return payload, nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"broker-service/auths"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc/metadata"
+)
+
+const tokenIssuer = "authentication-service"
+
+// roleAdmin is the role of the principals that can act on behalf of others
+const roleAdmin = "admin"
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+
+	// token is the access token of the principal, passed on to the services
+	// that authorize it themselves
+	token string
+}
+
+// TokenVerifier checks an access token and returns the principal it was issued to.
+type TokenVerifier interface {
+	Verify(ctx context.Context, token string) (*Principal, error)
+}
+
+// tokenClaims mirrors the claims issued by the authentication service
+type tokenClaims struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	// SessionID is the session the token was issued for, revoked on logout
+	SessionID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// localVerifier validates tokens in process, using the shared secret (HS256)
+// or the public key (RS256) of the authentication service. Only the
+// revocation of the session of a token is checked with the authentication
+// service, at address.
+type localVerifier struct {
+	method  jwt.SigningMethod
+	key     any
+	address string
+	timeout time.Duration
+	clients *grpcClients
+}
+
+func (v *localVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	var claims tokenClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(token *jwt.Token) (any, error) {
+		if token.Method.Alg() != v.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return v.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Issuer != tokenIssuer {
+		return nil, errors.New("invalid token issuer")
+	}
+
+	// access tokens have no audience, the other tokens of the authentication
+	// service, like email verification tokens, are signed with the same key
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("not an access token")
+	}
+
+	// tokens of older sessions have no session, and can't be revoked
+	if claims.SessionID != "" {
+		err = v.checkSession(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Principal{
+		UserID: claims.UserID,
+		Email:  claims.Email,
+		Role:   claims.Role,
+	}, nil
+}
+
+// checkSession returns an error when the session of token was revoked, or its
+// revocation can't be checked
+func (v *localVerifier) checkSession(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadata, requestIDFromContext(ctx))
+
+	conn, err := v.clients.Get(v.address)
+	if err != nil {
+		return err
+	}
+
+	c := auths.NewAuthServiceClient(conn)
+
+	res, err := c.CheckRevoked(ctx, &auths.TokenRequest{Token: token})
+	if err != nil {
+		return fmt.Errorf("checking the session: %w", err)
+	}
+
+	if res.Revoked {
+		return errors.New("session revoked")
+	}
+
+	return nil
+}
+
+// remoteVerifier asks the authentication service to validate tokens over gRPC.
+type remoteVerifier struct {
+	address string
+	timeout time.Duration
+	clients *grpcClients
+}
+
+func (v *remoteVerifier) Verify(ctx context.Context, token string) (*Principal, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, requestIDMetadata, requestIDFromContext(ctx))
+
+	conn, err := v.clients.Get(v.address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := auths.NewAuthServiceClient(conn)
+
+	res, err := c.ValidateToken(ctx, &auths.TokenRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+
+	if !res.Valid {
+		return nil, errors.New(res.Error)
+	}
+
+	return &Principal{
+		UserID: int(res.UserId),
+		Email:  res.Email,
+		Role:   res.Role,
+	}, nil
+}
+
+// newTokenVerifier configures token validation from the environment:
+//
+//	AUTH_VERIFY          local (default) or remote
+//	AUTH_GRPC_ADDRESS    authentication service used by remote validation, and
+//	                     to check the sessions of locally validated tokens
+//	JWT_ALGORITHM        HS256 (default) or RS256, for local validation
+//	JWT_SECRET           shared secret for HS256
+//	JWT_PUBLIC_KEY_FILE  PEM encoded RSA public key for RS256
+func newTokenVerifier(clients *grpcClients) (TokenVerifier, error) {
+	address := os.Getenv("AUTH_GRPC_ADDRESS")
+	if address == "" {
+		address = "authentication-service:50001"
+	}
+
+	switch mode := os.Getenv("AUTH_VERIFY"); mode {
+	case "", "local":
+		v, err := newLocalVerifier()
+		if err != nil {
+			return nil, err
+		}
+
+		v.address = address
+		v.timeout = time.Second
+		v.clients = clients
+
+		return v, nil
+
+	case "remote":
+		return &remoteVerifier{
+			address: address,
+			timeout: time.Second,
+			clients: clients,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported AUTH_VERIFY %s", mode)
+	}
+}
+
+func newLocalVerifier() (*localVerifier, error) {
+	switch alg := os.Getenv("JWT_ALGORITHM"); alg {
+	case "", "HS256":
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("JWT_SECRET is required for HS256")
+		}
+
+		return &localVerifier{
+			method: jwt.SigningMethodHS256,
+			key:    []byte(secret),
+		}, nil
+
+	case "RS256":
+		path := os.Getenv("JWT_PUBLIC_KEY_FILE")
+		if path == "" {
+			return nil, errors.New("JWT_PUBLIC_KEY_FILE is required for RS256")
+		}
+
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, err
+		}
+
+		return &localVerifier{
+			method: jwt.SigningMethodRS256,
+			key:    key,
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %s", alg)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"broker-service/auths"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"google.golang.org/grpc"
+)
+
+const testSecret = "test-secret"
+
+// revocationServer answers CheckRevoked with the sessions in revoked
+type revocationServer struct {
+	auths.UnimplementedAuthServiceServer
+	revoked map[string]bool
+}
+
+func (s revocationServer) CheckRevoked(ctx context.Context, req *auths.TokenRequest) (*auths.RevocationResponse, error) {
+	var claims tokenClaims
+	_, _, err := jwt.NewParser().ParseUnverified(req.GetToken(), &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auths.RevocationResponse{Revoked: s.revoked[claims.SessionID], SessionId: claims.SessionID}, nil
+}
+
+// testVerifier returns a local verifier of HS256 tokens signed with
+// testSecret, whose sessions are checked with a server that revoked revoked
+func testVerifier(t *testing.T, revoked ...string) *localVerifier {
+	t.Helper()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := revocationServer{revoked: make(map[string]bool)}
+	for _, sid := range revoked {
+		server.revoked[sid] = true
+	}
+
+	s := grpc.NewServer()
+	auths.RegisterAuthServiceServer(s, server)
+	go s.Serve(listen)
+	t.Cleanup(s.Stop)
+
+	clients := newGRPCClients()
+	t.Cleanup(clients.Close)
+
+	return &localVerifier{
+		method:  jwt.SigningMethodHS256,
+		key:     []byte(testSecret),
+		address: listen.Addr().String(),
+		timeout: time.Second,
+		clients: clients,
+	}
+}
+
+// signToken signs claims like the authentication service
+func signToken(t *testing.T, method jwt.SigningMethod, secret string, claims jwt.Claims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(method, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+// accessClaims returns the claims of an access token of session sid
+func accessClaims(sid string) tokenClaims {
+	return tokenClaims{
+		UserID:    1,
+		Email:     "admin@example.com",
+		Role:      roleAdmin,
+		SessionID: sid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   "1",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func TestLocalVerifierVerify(t *testing.T) {
+	expired := accessClaims("")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	otherIssuer := accessClaims("")
+	otherIssuer.Issuer = "someone-else"
+
+	// the claims of the email verification tokens of the authentication service
+	verification := struct {
+		UserID int    `json:"user_id"`
+		Email  string `json:"email"`
+		jwt.RegisteredClaims
+	}{
+		UserID: 1,
+		Email:  "admin@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   "1",
+			Audience:  jwt.ClaimStrings{"email-verification"},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "access token", token: signToken(t, jwt.SigningMethodHS256, testSecret, accessClaims(""))},
+		{name: "access token of an active session", token: signToken(t, jwt.SigningMethodHS256, testSecret, accessClaims("active"))},
+		{name: "access token of a revoked session", token: signToken(t, jwt.SigningMethodHS256, testSecret, accessClaims("revoked")), wantErr: true},
+		{name: "email verification token", token: signToken(t, jwt.SigningMethodHS256, testSecret, verification), wantErr: true},
+		{name: "expired token", token: signToken(t, jwt.SigningMethodHS256, testSecret, expired), wantErr: true},
+		{name: "other issuer", token: signToken(t, jwt.SigningMethodHS256, testSecret, otherIssuer), wantErr: true},
+		{name: "other secret", token: signToken(t, jwt.SigningMethodHS256, "other-secret", accessClaims("")), wantErr: true},
+		{name: "other signing method", token: signToken(t, jwt.SigningMethodHS384, testSecret, accessClaims("")), wantErr: true},
+		{name: "not a token", token: "not-a-token", wantErr: true},
+	}
+
+	v := testVerifier(t, "revoked")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := v.Verify(context.Background(), tt.token)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("token accepted for user %d", principal.UserID)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if principal.UserID != 1 || principal.Email != "admin@example.com" || principal.Role != roleAdmin {
+				t.Errorf("got principal %+v", principal)
+			}
+		})
+	}
+}
+
+func TestLocalVerifierRefusesUncheckedSessions(t *testing.T) {
+	v := testVerifier(t)
+	v.address = "127.0.0.1:1"
+	v.timeout = 100 * time.Millisecond
+
+	_, err := v.Verify(context.Background(), signToken(t, jwt.SigningMethodHS256, testSecret, accessClaims("active")))
+	if err == nil {
+		t.Fatal("token accepted while its session couldn't be checked")
+	}
+}
@@ -0,0 +1,213 @@
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// directReplyTo is the pseudo queue RabbitMQ delivers replies on, without
+// having to declare a reply queue per caller.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// ErrCallerClosed is returned by calls whose channel closed before their reply
+// arrived, and by calls made after the caller was closed.
+var ErrCallerClosed = errors.New("reply channel closed")
+
+// Caller sends requests over RabbitMQ and waits for their replies. Requests are
+// published with a correlation ID and the direct reply-to queue; replies are
+// matched back to the waiting call by their correlation ID. The channel of the
+// caller is opened again every time RabbitMQ is reconnected. Requests are sent
+// as CloudEvents.
+type Caller struct {
+	mode Mode
+
+	mu      sync.Mutex
+	channel *amqp.Channel
+	pending map[string]pendingCall
+	closed  bool
+}
+
+// pendingCall is a call waiting for its reply on channel
+type pendingCall struct {
+	reply   chan amqp.Delivery
+	channel *amqp.Channel
+}
+
+func NewCaller(conn *Connection, mode Mode) (*Caller, error) {
+	caller := &Caller{
+		mode:    mode,
+		pending: make(map[string]pendingCall),
+	}
+
+	err := conn.OnConnect(caller.open)
+	if err != nil {
+		return nil, err
+	}
+
+	return caller, nil
+}
+
+// open opens the channel of the caller on a new connection
+func (c *Caller) open(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = declareExchange(channel)
+	if err != nil {
+		channel.Close()
+		return err
+	}
+
+	// replies must be consumed, in no-ack mode, on the channel requests are published on
+	replies, err := channel.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		channel.Close()
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return channel.Close()
+	}
+	c.channel = channel
+
+	go c.dispatch(channel, replies)
+
+	return nil
+}
+
+// dispatch hands every reply to the call waiting for it. When the channel
+// closes, the calls still waiting on it are released.
+func (c *Caller) dispatch(channel *amqp.Channel, replies <-chan amqp.Delivery) {
+	for d := range replies {
+		c.mu.Lock()
+		call, ok := c.pending[d.CorrelationId]
+		delete(c.pending, d.CorrelationId)
+		c.mu.Unlock()
+
+		if ok {
+			call.reply <- d
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.channel == channel {
+		c.channel = nil
+	}
+	for id, call := range c.pending {
+		if call.channel == channel {
+			close(call.reply)
+			delete(c.pending, id)
+		}
+	}
+}
+
+// Call publishes data as a CloudEvent of the type of the routing key and waits
+// for its reply until ctx is done. The trace context of ctx is added to headers.
+func (c *Caller) Call(ctx context.Context, routingKey string, data []byte, headers amqp.Table) (amqp.Delivery, error) {
+	ctx, span := tracer.Start(ctx, routingKey+" call", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	id := newCorrelationID()
+	reply := make(chan amqp.Delivery, 1)
+
+	msg := NewCloudEvent(newCorrelationID(), routingKey, data, time.Now()).publishing(c.mode, headers)
+	// a request is worthless once its caller is gone
+	msg.DeliveryMode = amqp.Transient
+	msg.CorrelationId = id
+	msg.ReplyTo = directReplyTo
+
+	err := c.publish(id, reply, routingKey, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		publishedTotal.WithLabelValues(routingKey, "error").Inc()
+		return amqp.Delivery{}, err
+	}
+	publishedTotal.WithLabelValues(routingKey, "ok").Inc()
+
+	select {
+	case d, ok := <-reply:
+		if !ok {
+			span.SetStatus(codes.Error, ErrCallerClosed.Error())
+			return amqp.Delivery{}, ErrCallerClosed
+		}
+		return d, nil
+
+	case <-ctx.Done():
+		c.forget(id)
+		span.SetStatus(codes.Error, ctx.Err().Error())
+		return amqp.Delivery{}, ctx.Err()
+	}
+}
+
+// publish registers the call before publishing it, so its reply can't arrive first
+func (c *Caller) publish(id string, reply chan amqp.Delivery, routingKey string, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrCallerClosed
+	}
+	if c.channel == nil {
+		return ErrDisconnected
+	}
+
+	c.pending[id] = pendingCall{reply: reply, channel: c.channel}
+
+	err := c.channel.Publish("go-micro.events.tx", routingKey, false, false, msg)
+	if err != nil {
+		delete(c.pending, id)
+		return err
+	}
+
+	return nil
+}
+
+// forget stops waiting for the reply of a call, which is dropped if it arrives later
+func (c *Caller) forget(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, id)
+}
+
+// Close closes the channel of the caller, which is not opened again.
+func (c *Caller) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.channel == nil {
+		return nil
+	}
+
+	return c.channel.Close()
+}
+
+func newCorrelationID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	return hex.EncodeToString(b[:])
+}
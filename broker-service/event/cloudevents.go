@@ -0,0 +1,142 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// source is the CloudEvents source of the events of this service
+	source = "/broker-service"
+	// typePrefix prefixes the routing key of an event to make its CloudEvents type
+	typePrefix = "go-micro."
+
+	specVersion = "1.0"
+	// structuredContentType is the content type of events in structured mode
+	structuredContentType = "application/cloudevents+json"
+	// attributePrefix prefixes the application properties that carry the
+	// attributes of events in binary mode
+	attributePrefix = "cloudEvents:"
+)
+
+// Mode is the way events are laid out in AMQP messages.
+type Mode int
+
+const (
+	// Structured puts the whole event, as JSON, in the body of the message.
+	Structured Mode = iota
+	// Binary puts the data of the event in the body of the message, and its
+	// attributes in application properties.
+	Binary
+)
+
+// ParseMode returns the mode named structured or binary (structured if empty).
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "", "structured":
+		return Structured, nil
+	case "binary":
+		return Binary, nil
+	default:
+		return Structured, fmt.Errorf("unknown event mode %s", name)
+	}
+}
+
+// CloudEvent is an event in the CloudEvents 1.0 format, with JSON data.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// NewCloudEvent returns an event of this service with the type of the routing
+// key and the JSON data.
+func NewCloudEvent(id, routingKey string, data []byte, t time.Time) CloudEvent {
+	return CloudEvent{
+		SpecVersion:     specVersion,
+		ID:              id,
+		Source:          source,
+		Type:            typePrefix + routingKey,
+		Time:            t.UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// RoutingKey returns the routing key the type of the event was derived from.
+func (evt CloudEvent) RoutingKey() string {
+	return strings.TrimPrefix(evt.Type, typePrefix)
+}
+
+// publishing lays the event out in a persistent message, in the given mode
+func (evt CloudEvent) publishing(mode Mode, headers amqp.Table) amqp.Publishing {
+	msg := amqp.Publishing{
+		DeliveryMode: amqp.Persistent,
+		MessageId:    evt.ID,
+		Timestamp:    evt.Time,
+		Headers:      headers,
+	}
+
+	if mode == Binary {
+		headers[attributePrefix+"specversion"] = evt.SpecVersion
+		headers[attributePrefix+"id"] = evt.ID
+		headers[attributePrefix+"source"] = evt.Source
+		headers[attributePrefix+"type"] = evt.Type
+		headers[attributePrefix+"time"] = evt.Time.Format(time.RFC3339Nano)
+		msg.ContentType = evt.DataContentType
+		msg.Body = evt.Data
+		return msg
+	}
+
+	msg.ContentType = structuredContentType
+	msg.Body, _ = json.Marshal(evt)
+
+	return msg
+}
+
+// DecodeCloudEvent returns the CloudEvent carried by d, in structured or binary
+// mode. It reports false for messages that are not CloudEvents.
+func DecodeCloudEvent(d amqp.Delivery) (CloudEvent, bool, error) {
+	var evt CloudEvent
+
+	switch {
+	case strings.HasPrefix(d.ContentType, structuredContentType):
+		err := json.Unmarshal(d.Body, &evt)
+		if err != nil {
+			return evt, true, err
+		}
+
+	case d.Headers[attributePrefix+"specversion"] != nil:
+		evt.SpecVersion, _ = d.Headers[attributePrefix+"specversion"].(string)
+		evt.ID, _ = d.Headers[attributePrefix+"id"].(string)
+		evt.Source, _ = d.Headers[attributePrefix+"source"].(string)
+		evt.Type, _ = d.Headers[attributePrefix+"type"].(string)
+		if t, ok := d.Headers[attributePrefix+"time"].(string); ok {
+			evt.Time, _ = time.Parse(time.RFC3339Nano, t)
+		}
+		evt.DataContentType = d.ContentType
+		evt.Data = d.Body
+
+	default:
+		return evt, false, nil
+	}
+
+	if evt.SpecVersion != specVersion {
+		return evt, true, fmt.Errorf("unsupported CloudEvents specversion %q", evt.SpecVersion)
+	}
+
+	if evt.ID == "" || evt.Source == "" || evt.Type == "" {
+		return evt, true, errors.New("CloudEvent without id, source or type")
+	}
+
+	return evt, true, nil
+}
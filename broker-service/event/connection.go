@@ -0,0 +1,228 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// dialAttempts bounds the attempts to connect at startup
+	dialAttempts = 5
+	// maxReconnectBackoff bounds the wait between two attempts to reconnect
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// ErrDisconnected is returned while the connection to RabbitMQ is down.
+var ErrDisconnected = errors.New("not connected to RabbitMQ")
+
+// Connection is a connection to RabbitMQ that is dialed again, with backoff,
+// whenever it closes. Users that declare exchanges, queues or consumers
+// register them with OnConnect, so they are set up again on every new
+// connection.
+type Connection struct {
+	url string
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	// ready is closed while connected
+	ready  chan struct{}
+	closed bool
+
+	// setupMu makes sure every setup runs once per connection
+	setupMu sync.Mutex
+	setups  []func(*amqp.Connection) error
+}
+
+// Dial connects to url, retrying with backoff until RabbitMQ is ready, and
+// keeps the connection open until Close is called.
+func Dial(url string) (*Connection, error) {
+	var counts int64
+	var backOff = 1 * time.Second
+
+	c := &Connection{
+		url:   url,
+		ready: make(chan struct{}),
+	}
+
+	// don't continue until rabbit is ready
+	for {
+		conn, err := amqp.Dial(url)
+		if err == nil {
+			log.Println("Connected to RabbitMQ!")
+			closing := conn.NotifyClose(make(chan *amqp.Error, 1))
+			c.connected(conn)
+			go c.watch(closing)
+			return c, nil
+		}
+
+		fmt.Println("RabbitMQ not yet ready...")
+		counts++
+
+		if counts > dialAttempts {
+			fmt.Println(err)
+			return nil, err
+		}
+
+		backOff = time.Duration(math.Pow(float64(counts), 2)) * time.Second
+		log.Println("backing off...")
+		time.Sleep(backOff)
+	}
+}
+
+// Channel opens a channel on the current connection, or returns ErrDisconnected.
+func (c *Connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil || conn.IsClosed() {
+		return nil, ErrDisconnected
+	}
+
+	return conn.Channel()
+}
+
+// Wait blocks until the connection is up, or returns the error of ctx.
+func (c *Connection) Wait(ctx context.Context) error {
+	c.mu.Lock()
+	ready := c.ready
+	c.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OnConnect runs setup on the current connection, and again on every new
+// connection once RabbitMQ is reconnected. The error of the first run is
+// returned; when a later run fails, the connection is closed and dialed again.
+func (c *Connection) OnConnect(setup func(*amqp.Connection) error) error {
+	c.setupMu.Lock()
+	defer c.setupMu.Unlock()
+
+	c.setups = append(c.setups, setup)
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrDisconnected
+	}
+
+	return setup(conn)
+}
+
+// Close closes the connection, which is not dialed again.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+
+	return c.conn.Close()
+}
+
+// connected makes conn the current connection. It reports false, closing conn,
+// if Close was called meanwhile.
+func (c *Connection) connected(conn *amqp.Connection) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		_ = conn.Close()
+		return false
+	}
+
+	c.conn = conn
+	close(c.ready)
+	rabbitConnected.Set(1)
+
+	return true
+}
+
+// watch dials RabbitMQ again every time the current connection closes, until
+// Close is called
+func (c *Connection) watch(closing chan *amqp.Error) {
+	for {
+		reason := <-closing
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		rabbitConnected.Set(0)
+		log.Println("Lost connection to RabbitMQ:", reason)
+
+		closing = c.redial()
+		if closing == nil {
+			return
+		}
+	}
+}
+
+// redial connects to RabbitMQ with an increasing backoff and runs the setups
+// on the new connection. It returns the channel notified when the new
+// connection closes, or nil if Close was called meanwhile.
+func (c *Connection) redial() chan *amqp.Error {
+	for attempt := 1; ; attempt++ {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return nil
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err != nil {
+			backOff := time.Duration(math.Pow(float64(attempt), 2)) * time.Second
+			if backOff > maxReconnectBackoff {
+				backOff = maxReconnectBackoff
+			}
+
+			log.Printf("Reconnecting to RabbitMQ in %s: %v\n", backOff, err)
+			time.Sleep(backOff)
+			continue
+		}
+
+		// registered first, so a close during the setups isn't missed
+		closing := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		if !c.connected(conn) {
+			return nil
+		}
+		log.Println("Reconnected to RabbitMQ!")
+		rabbitReconnects.Inc()
+
+		c.setupMu.Lock()
+		for _, setup := range c.setups {
+			err = setup(conn)
+			if err != nil {
+				log.Println("Error setting up RabbitMQ connection, reconnecting:", err)
+				_ = conn.Close()
+				break
+			}
+		}
+		c.setupMu.Unlock()
+
+		return closing
+	}
+}
@@ -1,59 +1,355 @@
 package event
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// confirmTimeout bounds the wait for RabbitMQ to confirm an event
+const confirmTimeout = 5 * time.Second
+
+var (
+	// ErrBufferFull is returned by Push while disconnected once the buffer of
+	// the emitter is full.
+	ErrBufferFull = errors.New("not connected to RabbitMQ and the event buffer is full")
+	// ErrNacked is returned by Push when RabbitMQ could not take the event.
+	ErrNacked = errors.New("event rejected by RabbitMQ")
+	// ErrUnroutable is returned by Push when no queue is bound for the routing
+	// key of the event.
+	ErrUnroutable = errors.New("no queue is bound for the event")
+)
+
+// Emitter publishes CloudEvents to the go-micro.events.tx exchange on a
+// long-lived channel in confirm mode. Events are persistent and mandatory, so
+// Push only succeeds once RabbitMQ has routed the event to a queue and
+// confirmed it.
+// While RabbitMQ is disconnected, up to bufferSize events are kept and
+// published once it is reconnected; without a buffer, Push fails fast.
 type Emitter struct {
-	connection *amqp.Connection
+	connection *Connection
+	bufferSize int
+	mode       Mode
+
+	// mu guards the channel, whose publishings must get consecutive sequence numbers
+	mu      sync.Mutex
+	channel *confirmChannel
+
+	bufferMu sync.Mutex
+	buffer   []bufferedEvent
+}
+
+// confirmChannel is a channel in confirm mode with the publishings waiting for
+// their confirmation, by sequence number
+type confirmChannel struct {
+	*amqp.Channel
+	pending map[uint64]pendingPublish
+}
+
+// pendingPublish is a publishing waiting for its confirmation
+type pendingPublish struct {
+	messageID string
+	done      chan error
 }
 
-func (e *Emitter) setup() error {
-	channel, err := e.connection.Channel()
+// bufferedEvent is an event pushed while disconnected
+type bufferedEvent struct {
+	routingKey string
+	msg        amqp.Publishing
+}
+
+// setup opens the channel of the emitter on a new connection and publishes the
+// events buffered while disconnected
+func (e *Emitter) setup(conn *amqp.Connection) error {
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	err = e.open(channel)
+	e.mu.Unlock()
 	if err != nil {
+		channel.Close()
 		return err
 	}
-	defer channel.Close()
 
-	return declareExchange(channel)
+	return e.flush()
 }
 
-func (e *Emitter) Push(event string, severity string) error {
-	channel, err := e.connection.Channel()
+// open puts channel in confirm mode and makes it the channel of the emitter.
+// e.mu must be held.
+func (e *Emitter) open(channel *amqp.Channel) error {
+	err := declareExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	err = channel.Confirm(false)
 	if err != nil {
 		return err
 	}
-	defer channel.Close()
+
+	// both are unbuffered: RabbitMQ returns an unroutable message before it
+	// confirms it, and confirm must see them in that order
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation))
+	returns := channel.NotifyReturn(make(chan amqp.Return))
+
+	e.channel = &confirmChannel{
+		Channel: channel,
+		pending: make(map[uint64]pendingPublish),
+	}
+
+	go e.confirm(e.channel, confirms, returns)
+
+	return nil
+}
+
+// confirm settles the publishings of channel as RabbitMQ returns and confirms
+// them. When the channel closes, the publishings still waiting fail with
+// ErrDisconnected.
+func (e *Emitter) confirm(channel *confirmChannel, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	returned := make(map[string]amqp.Return)
+
+	for {
+		select {
+		case r, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			returned[r.MessageId] = r
+
+		case c, ok := <-confirms:
+			if !ok {
+				e.closed(channel)
+				return
+			}
+
+			e.mu.Lock()
+			p, found := channel.pending[c.DeliveryTag]
+			delete(channel.pending, c.DeliveryTag)
+			e.mu.Unlock()
+
+			if !found {
+				continue
+			}
+
+			r, wasReturned := returned[p.messageID]
+			delete(returned, p.messageID)
+
+			switch {
+			case !c.Ack:
+				p.done <- ErrNacked
+			case wasReturned:
+				p.done <- fmt.Errorf("%w: %s (%s)", ErrUnroutable, r.RoutingKey, r.ReplyText)
+			default:
+				p.done <- nil
+			}
+		}
+	}
+}
+
+// closed forgets channel once it is closed, failing its pending publishings
+func (e *Emitter) closed(channel *confirmChannel) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.channel == channel {
+		e.channel = nil
+	}
+
+	for tag, p := range channel.pending {
+		p.done <- ErrDisconnected
+		delete(channel.pending, tag)
+	}
+}
+
+// send publishes msg on the channel of the emitter, opening a new one if it
+// was closed, and returns the channel its confirmation is sent on
+func (e *Emitter) send(routingKey string, msg amqp.Publishing) (<-chan error, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.channel == nil {
+		channel, err := e.connection.Channel()
+		if err != nil {
+			return nil, err
+		}
+
+		err = e.open(channel)
+		if err != nil {
+			channel.Close()
+			return nil, err
+		}
+	}
+
+	seq := e.channel.GetNextPublishSeqNo()
+	done := make(chan error, 1)
+	e.channel.pending[seq] = pendingPublish{messageID: msg.MessageId, done: done}
+
+	err := e.channel.Publish("go-micro.events.tx", routingKey, true, false, msg)
+	if err != nil {
+		delete(e.channel.pending, seq)
+		return nil, err
+	}
+
+	return done, nil
+}
+
+// publish publishes msg and waits for RabbitMQ to confirm it
+func (e *Emitter) publish(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	ctx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	defer cancel()
+
+	done, err := e.send(routingKey, msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for RabbitMQ to confirm the event: %w", ctx.Err())
+	}
+}
+
+// flush publishes the buffered events in order. Events RabbitMQ doesn't take
+// are dropped; flushing stops when disconnected again, keeping the rest.
+func (e *Emitter) flush() error {
+	e.bufferMu.Lock()
+	defer e.bufferMu.Unlock()
+
+	for len(e.buffer) > 0 {
+		evt := e.buffer[0]
+
+		err := e.publish(context.Background(), evt.routingKey, evt.msg)
+		if IsDisconnected(err) {
+			return err
+		}
+
+		if err != nil {
+			log.Println("Error publishing buffered event, dropping it:", evt.routingKey, err)
+		}
+		publishedTotal.WithLabelValues(evt.routingKey, publishResult(err)).Inc()
+		e.buffer = e.buffer[1:]
+	}
+
+	return nil
+}
+
+// enqueue buffers an event pushed while disconnected, and reports whether there was room for it
+func (e *Emitter) enqueue(routingKey string, msg amqp.Publishing) bool {
+	e.bufferMu.Lock()
+	defer e.bufferMu.Unlock()
+
+	if len(e.buffer) >= e.bufferSize {
+		return false
+	}
+
+	e.buffer = append(e.buffer, bufferedEvent{routingKey: routingKey, msg: msg})
+
+	return true
+}
+
+// Push publishes data as a CloudEvent of the type of the routing key, and waits
+// for RabbitMQ to confirm it. The trace context of ctx is added to headers, so
+// consumers can continue the trace. While RabbitMQ is disconnected the event
+// is buffered, or ErrDisconnected (ErrBufferFull with a buffer) is returned.
+func (e *Emitter) Push(ctx context.Context, routingKey string, data []byte, headers amqp.Table) error {
+	return e.push(ctx, NewCloudEvent(newCorrelationID(), routingKey, data, time.Now()), headers, true)
+}
+
+// Publish publishes evt like Push, but never buffers it: it is meant for
+// publishers that keep their events until they are confirmed, like an outbox.
+func (e *Emitter) Publish(ctx context.Context, evt CloudEvent, headers amqp.Table) error {
+	return e.push(ctx, evt, headers, false)
+}
+
+// push publishes evt, buffering it while disconnected if buffer is set
+func (e *Emitter) push(ctx context.Context, evt CloudEvent, headers amqp.Table, buffer bool) error {
+	routingKey := evt.RoutingKey()
+
+	ctx, span := tracer.Start(ctx, routingKey+" publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	msg := evt.publishing(e.mode, headers)
 
 	log.Println("Pushing to channel")
 
-	err = channel.Publish(
-		"go-micro.events.tx",
-		severity,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(event),
-		},
-	)
+	err := e.publish(ctx, routingKey, msg)
+	if IsDisconnected(err) && buffer && e.bufferSize > 0 {
+		if e.enqueue(routingKey, msg) {
+			log.Println("RabbitMQ is disconnected, buffering event", routingKey)
+			publishedTotal.WithLabelValues(routingKey, "buffered").Inc()
+			return nil
+		}
+		err = ErrBufferFull
+	}
+
+	publishedTotal.WithLabelValues(routingKey, publishResult(err)).Inc()
+
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
 	return nil
 }
 
-func NewEventEmitter(conn *amqp.Connection) (Emitter, error) {
-	emitter := Emitter{
+// publishResult is the result label of a publishing that ended with err
+func publishResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNacked):
+		return "nacked"
+	case errors.Is(err, ErrUnroutable):
+		return "unroutable"
+	default:
+		return "error"
+	}
+}
+
+// IsDisconnected reports whether err means the connection to RabbitMQ is down.
+func IsDisconnected(err error) bool {
+	return errors.Is(err, ErrDisconnected) || errors.Is(err, amqp.ErrClosed)
+}
+
+// EmitterConfig configures an emitter.
+type EmitterConfig struct {
+	// BufferSize is the number of events kept while disconnected (none if 0)
+	BufferSize int
+	// Mode is the way events are laid out in messages
+	Mode Mode
+}
+
+// NewEventEmitter returns an emitter publishing on conn.
+func NewEventEmitter(conn *Connection, config EmitterConfig) (*Emitter, error) {
+	emitter := &Emitter{
 		connection: conn,
+		bufferSize: config.BufferSize,
+		mode:       config.Mode,
 	}
 
-	err := emitter.setup()
+	err := conn.OnConnect(emitter.setup)
 	if err != nil {
-		return Emitter{}, err
+		return nil, err
 	}
 
 	return emitter, nil
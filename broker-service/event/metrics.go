@@ -0,0 +1,26 @@
+package event
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	publishedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "broker",
+		Name:      "rabbitmq_published_total",
+		Help:      "Messages published to RabbitMQ, by routing key and result (ok, buffered, nacked, unroutable or error).",
+	}, []string{"routing_key", "result"})
+
+	rabbitConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "broker",
+		Name:      "rabbitmq_connected",
+		Help:      "Whether the connection to RabbitMQ is up (1) or being dialed again (0).",
+	})
+
+	rabbitReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "broker",
+		Name:      "rabbitmq_reconnects_total",
+		Help:      "Connections to RabbitMQ dialed again after the previous one closed.",
+	})
+)
@@ -0,0 +1,57 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Subscribe consumes the events published with any of the routing keys and
+// passes them to handle. Each subscriber has its own exclusive queue, so every
+// instance of the broker receives all the events. The queue is declared and
+// bound again every time RabbitMQ is reconnected.
+func Subscribe(conn *Connection, keys []string, handle func(amqp.Delivery)) error {
+	return conn.OnConnect(func(conn *amqp.Connection) error {
+		return subscribe(conn, keys, handle)
+	})
+}
+
+// subscribe consumes the events of the routing keys on a new connection
+func subscribe(conn *amqp.Connection, keys []string, handle func(amqp.Delivery)) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	err = declareExchange(ch)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	q, err := declareRandomQueue(ch)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	for _, key := range keys {
+		err = ch.QueueBind(q.Name, key, "go-micro.events.tx", false, nil)
+		if err != nil {
+			ch.Close()
+			return err
+		}
+	}
+
+	deliveries, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return err
+	}
+
+	go func() {
+		for d := range deliveries {
+			handle(d)
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("broker-service/event")
+
+// headerCarrier lets the trace context travel in the headers of AMQP messages
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
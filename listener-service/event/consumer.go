@@ -1,24 +1,97 @@
 package event
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"regexp"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The correlation ID of a request is received in this AMQP message header and
+// passed on in this HTTP header.
+const (
+	requestIDAMQPHeader = "x-request-id"
+	requestIDHeader     = "X-Request-ID"
 )
 
 type Consumer struct {
-	conn      *amqp.Connection
-	// queueName string
+	conn            *Connection
+	queueName       string
+	maxDeliveries   int
+	retryDelays     []time.Duration
+	workers         int
+	limits          topicLimits
+	shutdownTimeout time.Duration
+	handlers        *Registry
+	dedupe          DedupeStore
+}
+
+// ConsumerConfig configures the queues of a consumer.
+type ConsumerConfig struct {
+	// Queue is the durable queue consumed. Its dead letters go to Queue.dead and
+	// the messages that failed every retry to Queue.parking.
+	Queue string
+	// MaxDeliveries is the number of deliveries of a message before RabbitMQ
+	// dead-letters it
+	MaxDeliveries int
+	// RetryDelays are the delays before each retry of a message that failed for
+	// a transient reason
+	RetryDelays []time.Duration
+	// Workers is the number of messages handled at once, and the prefetch of
+	// the channel (DefaultWorkers if not set)
+	Workers int
+	// TopicLimits bounds the number of messages of a routing key handled at once
+	TopicLimits map[string]int
+	// ShutdownTimeout is how long in-flight messages are waited for when the
+	// consumer stops (DefaultShutdownTimeout if not set)
+	ShutdownTimeout time.Duration
+	// Handlers routes the events to their handler; the queue is bound with
+	// their routing keys
+	Handlers *Registry
+	// Dedupe remembers the IDs of the messages handled successfully, so they
+	// are not handled again when redelivered (no deduplication if nil)
+	Dedupe DedupeStore
 }
 
-func NewConsumer(conn *amqp.Connection) (Consumer, error) {
+// Defaults of the consumer configuration.
+const (
+	DefaultWorkers         = 10
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// reconnectDelay is the wait before consuming again once the channel of the
+// consumer closed, giving the connection time to notice it is lost
+const reconnectDelay = time.Second
+
+// NewConsumer returns a consumer of the queue of config, declaring it with its
+// dead-letter, delay and parking queues.
+func NewConsumer(conn *Connection, config ConsumerConfig) (Consumer, error) {
 	consumer := Consumer{
-		conn: conn,
+		conn:            conn,
+		queueName:       config.Queue,
+		maxDeliveries:   config.MaxDeliveries,
+		retryDelays:     config.RetryDelays,
+		workers:         config.Workers,
+		limits:          newTopicLimits(config.TopicLimits),
+		shutdownTimeout: config.ShutdownTimeout,
+		handlers:        config.Handlers,
+		dedupe:          config.Dedupe,
+	}
+
+	if consumer.workers <= 0 {
+		consumer.workers = DefaultWorkers
+	}
+
+	if consumer.shutdownTimeout <= 0 {
+		consumer.shutdownTimeout = DefaultShutdownTimeout
 	}
 
 	err := consumer.setup()
@@ -34,13 +107,32 @@ func (consumer *Consumer) setup() error {
 	if err != nil {
 		return err
 	}
+	defer channel.Close()
+
+	err = declareExchange(channel)
+	if err != nil {
+		return err
+	}
+
+	err = declareDeadLetterQueue(channel, consumer.queueName)
+	if err != nil {
+		return err
+	}
+
+	err = declareRetryQueues(channel, consumer.queueName, consumer.retryDelays)
+	if err != nil {
+		return err
+	}
 
-	return declareExchange(channel)
+	_, err = declareQueue(channel, consumer.queueName, consumer.maxDeliveries)
+	return err
 }
 
+// RabbitPayload is the routing key and data of an event. Older publishers send
+// it as the message body, with the routing key as severity.
 type RabbitPayload struct {
-	Severity string `json:"severity"`
-	Data     any    `json:"data"`
+	Severity string          `json:"severity"`
+	Data     json.RawMessage `json:"data"`
 }
 
 type MailPayload struct {
@@ -53,6 +145,14 @@ type MailPayload struct {
 type AuthPayload struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// ClientAddress is the address of the client of the broker, whose failed
+	// logins are counted for it rather than for the listener
+	ClientAddress string `json:"client_address,omitempty"`
+}
+
+// ForwardedFor returns the address of the client the event was emitted for.
+func (p *AuthPayload) ForwardedFor() string {
+	return p.ClientAddress
 }
 
 type LogPayload struct {
@@ -60,21 +160,52 @@ type LogPayload struct {
 	Data string `json:"data"`
 }
 
-func (consumer *Consumer) Listen(topics []string) error {
-	ch, err := consumer.conn.Channel()
+// Listen binds the queue with the routing keys of the handlers and consumes it with a pool of workers until
+// ctx is done. It then stops consuming and waits for the in-flight messages to
+// be handled, up to the shutdown timeout; messages that are still unacked are
+// redelivered once the channel is closed. When the connection to RabbitMQ is
+// lost, Listen waits for it to be dialed again and declares, binds and
+// consumes the queue again.
+func (consumer *Consumer) Listen(ctx context.Context) error {
+	for {
+		err := consumer.conn.Wait(ctx)
+		if err != nil {
+			// stopped while disconnected, nothing is in flight
+			return nil
+		}
+
+		stopped, err := consumer.consume(ctx)
+		if stopped {
+			return err
+		}
+
+		log.Println("Consumer stopped, waiting for RabbitMQ:", err)
+
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// consume consumes the queue on a new channel until ctx is done, which it
+// reports with the error of the shutdown, or until the channel closes.
+func (consumer *Consumer) consume(ctx context.Context) (bool, error) {
+	err := consumer.setup()
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer ch.Close()
 
-	q, err := declareRandomQueue(ch)
+	ch, err := consumer.conn.Channel()
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer ch.Close()
 
-	for _, s := range topics {
-		ch.QueueBind(
-			q.Name,
+	for _, s := range consumer.handlers.Keys() {
+		err = ch.QueueBind(
+			consumer.queueName,
 			s,
 			"go-micro.events.tx",
 			false,
@@ -82,145 +213,220 @@ func (consumer *Consumer) Listen(topics []string) error {
 		)
 
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
-	messages, err := ch.Consume(q.Name, "", true, false, false, false, nil)
+	// RabbitMQ sends at most one unacked message per worker
+	err = ch.Qos(consumer.workers, 0, false)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	forever := make(chan bool)
-	go func() {
-		for d := range messages {
-			var payload RabbitPayload
-			_ = json.Unmarshal(d.Body, &payload)
+	tag := consumerTag(consumer.queueName)
 
-			go handlePayload(payload)
-		}
-	}()
+	messages, err := ch.Consume(consumer.queueName, tag, false, false, false, false, nil)
+	if err != nil {
+		return false, err
+	}
 
-	fmt.Printf("Waiting for message [Exchange, Queue] [go-micro.events.tx, %s]\n", q.Name)
-	<-forever
+	var wg sync.WaitGroup
+	for i := 0; i < consumer.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 
-	return nil
-}
+			for d := range messages {
+				consumer.work(ch, d)
+			}
+		}()
+	}
 
-func handlePayload(payload RabbitPayload) {
-	var logPat = regexp.MustCompile(`^[(log)(event)].*`)
-	var authPat = regexp.MustCompile(`^auth.*`)
-	var mailPat = regexp.MustCompile(`^mail.*`)
+	// closed once every worker is done, after the deliveries stop
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
 
-	switch severity := payload.Severity; {
-	case logPat.MatchString(severity):
-		// log
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+	fmt.Printf("Waiting for message [Exchange, Queue] [go-micro.events.tx, %s]\n", consumer.queueName)
 
-	case authPat.MatchString(severity):
-		// authenticate
-		err := authenticateUser(payload)
-		if err != nil {
-			log.Println(err)
-		}
+	select {
+	case <-drained:
+		return false, errors.New("consumer channel closed")
+	case <-ctx.Done():
+	}
 
-	case mailPat.MatchString(severity):
-		// send mail
-		err := sendMail(payload)
-		if err != nil {
-			log.Println(err)
-		}
+	log.Println("Stopping consumer, waiting for in-flight messages...")
 
-	default:
-		err := logEvent(payload)
-		if err != nil {
-			log.Println(err)
-		}
+	err = ch.Cancel(tag, false)
+	if err != nil {
+		return true, err
+	}
+
+	select {
+	case <-drained:
+		return true, nil
+	case <-time.After(consumer.shutdownTimeout):
+		return true, errors.New("timeout waiting for in-flight messages, they will be redelivered")
 	}
 }
 
-func logEvent(entry RabbitPayload) error {
-	jsonData, _ := json.MarshalIndent(entry.Data, "", "\t")
+// work handles d once its topic is below its concurrency limit
+func (consumer *Consumer) work(ch *amqp.Channel, d amqp.Delivery) {
+	release := consumer.limits.acquire(routingKey(d))
+	defer release()
+
+	inFlight.Inc()
+	defer inFlight.Dec()
 
-	logServiceURL := "http://logger-service/log"
+	consumer.handleDelivery(ch, d)
+}
 
-	request, err := http.NewRequest("POST", logServiceURL, bytes.NewBuffer(jsonData))
+// handleDelivery handles an event and settles its message. Messages are acked once
+// handled, even when the service rejected them, and retried later when the
+// handler failed for a transient reason. Messages that can't be decoded or
+// whose payload is invalid are dead-lettered right away, with the reason, and
+// messages whose ID was already handled successfully are acked without
+// handling them again.
+func (consumer *Consumer) handleDelivery(ch *amqp.Channel, d amqp.Delivery) {
+	payload, err := decodePayload(d)
 	if err != nil {
-		return err
+		rejectErr := consumer.reject(ch, d, fmt.Errorf("decoding message: %w", err))
+		if rejectErr != nil {
+			log.Println("Error rejecting message:", rejectErr)
+		}
+		return
 	}
 
-	request.Header.Set("Content-Type", "application/json")
+	consumedTotal.WithLabelValues(routingKey(d)).Inc()
 
-	client := &http.Client{}
+	requestID, _ := d.Headers[requestIDAMQPHeader].(string)
+	jobID, _ := d.Headers[jobIDAMQPHeader].(string)
 
-	response, err := client.Do(request)
-	if err != nil {
-		return err
+	// continue the trace of the request that emitted the event
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(d.Headers))
+
+	if consumer.handled(ctx, d) {
+		log.Println("Skipping message already handled:", d.MessageId)
+		dedupedTotal.WithLabelValues(routingKey(d)).Inc()
+		_ = d.Ack(false)
+		return
 	}
-	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusAccepted {
-		return err
+	// publishers that don't wait for a reply follow the job instead
+	if jobID != "" {
+		publishJobStatus(ch, jobID, requestID, JobProcessing, nil)
 	}
 
-	return nil
-}
+	reply, err := consumer.handlePayload(ctx, payload, requestID)
+	if err == nil {
+		consumer.markHandled(ctx, d)
+	}
 
-func authenticateUser(entry RabbitPayload) error {
-	// create some json we'll send to the auth microservice
-	jsonData, _ := json.MarshalIndent(entry.Data, "", "\t")
+	switch {
+	case isInvalid(err):
+		// the publisher and the job learn why, the message is kept for inspection
+		if d.ReplyTo != "" {
+			replyErr := sendReply(ch, d, reply)
+			if replyErr != nil {
+				log.Println("Error replying to", d.ReplyTo, replyErr)
+			}
+		}
+		if jobID != "" {
+			publishJobStatus(ch, jobID, requestID, JobFailed, err)
+		}
 
-	// call the service
-	request, err := http.NewRequest("POST", "http://authentication-service/authenticate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return err
+		rejectErr := consumer.reject(ch, d, err)
+		if rejectErr != nil {
+			log.Println("Error rejecting message:", rejectErr)
+		}
+
+	case d.ReplyTo != "":
+		// the publisher waits for the result, retrying is up to it
+		err = sendReply(ch, d, reply)
+		if err != nil {
+			log.Println("Error replying to", d.ReplyTo, err)
+		}
+		_ = d.Ack(false)
+
+	case isTransient(err):
+		parked, retryErr := consumer.retry(ch, d, err)
+		if retryErr != nil {
+			log.Println("Error retrying message:", retryErr)
+		}
+
+		// a parked job only succeeds if an operator replays it
+		if jobID != "" && parked {
+			publishJobStatus(ch, jobID, requestID, JobFailed, err)
+		}
+
+	default:
+		if jobID != "" {
+			status := JobSucceeded
+			if err != nil {
+				status = JobFailed
+			}
+			publishJobStatus(ch, jobID, requestID, status, err)
+		}
+		_ = d.Ack(false)
 	}
+}
 
-	client := &http.Client{}
-	response, err := client.Do(request)
-	if err != nil {
-		return err
+// handled reports whether the message ID of d was already handled successfully.
+// Messages without an ID, or that can't be checked, are handled.
+func (consumer *Consumer) handled(ctx context.Context, d amqp.Delivery) bool {
+	if consumer.dedupe == nil || d.MessageId == "" {
+		return false
 	}
-	defer response.Body.Close()
 
-	// make sure we get back the correct status code
-	if response.StatusCode == http.StatusUnauthorized {
-		return err
-	} else if response.StatusCode != http.StatusAccepted {
-		return err
+	seen, err := consumer.dedupe.Seen(ctx, d.MessageId)
+	if err != nil {
+		log.Println("Error checking message ID, handling it anyway:", err)
+		return false
 	}
 
-	return nil
+	return seen
 }
 
-func sendMail(entry RabbitPayload) error {
-	jsonData, _ := json.MarshalIndent(entry.Data, "", "\t")
-
-	// call the mail service
-	mailServiceURL := "http://mailer-service/send"
+// markHandled records that the message ID of d was handled successfully
+func (consumer *Consumer) markHandled(ctx context.Context, d amqp.Delivery) {
+	if consumer.dedupe == nil || d.MessageId == "" {
+		return
+	}
 
-	// post to mail service
-	request, err := http.NewRequest("POST", mailServiceURL, bytes.NewBuffer(jsonData))
+	err := consumer.dedupe.Mark(ctx, d.MessageId)
 	if err != nil {
-		return err
+		log.Println("Error recording message ID:", err)
 	}
+}
+
+// handlePayload calls the handler of the event, passing on the correlation ID
+// of the request that emitted it, and returns the reply sent to publishers
+// that wait for one, with the error of the handler
+func (consumer *Consumer) handlePayload(ctx context.Context, payload RabbitPayload, requestID string) (Reply, error) {
+	handler := consumer.handlers.Match(payload.Severity)
 
-	request.Header.Set("Content-Type", "application/json")
+	ctx, span := tracer.Start(ctx, payload.Severity+" process", trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	start := time.Now()
+
+	reply, err := handler.handle(ctx, payload.Severity, payload.Data, requestID)
+	observeHandler(handler.Name, start, err)
 
-	client := &http.Client{}
-	response, err := client.Do(request)
 	if err != nil {
-		return err
+		log.Println(err)
 	}
-	defer response.Body.Close()
 
-	// make sure we get back the right status code
-	if response.StatusCode != http.StatusAccepted {
-		return err
+	if reply != nil {
+		return *reply, err
+	}
+
+	// the handler failed before the target answered
+	if isTransient(err) {
+		return newReply(http.StatusServiceUnavailable, true, err.Error()), err
 	}
 
-	return nil
+	return newReply(http.StatusBadRequest, true, err.Error()), err
 }
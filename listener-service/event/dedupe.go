@@ -0,0 +1,119 @@
+package event
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DedupeStore remembers the IDs of the messages that were handled
+// successfully, so a redelivered message isn't handled twice.
+type DedupeStore interface {
+	// Seen reports whether the message with the given ID was handled.
+	Seen(ctx context.Context, id string) (bool, error)
+	// Mark records that the message with the given ID was handled.
+	Mark(ctx context.Context, id string) error
+}
+
+// memoryDedupe keeps the last size message IDs in memory, for ttl. The least
+// recently handled IDs are forgotten first.
+type memoryDedupe struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+type dedupeEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryDedupe returns a dedupe store that keeps up to size message IDs in
+// memory for ttl. It only knows the messages handled by this listener.
+func NewMemoryDedupe(size int, ttl time.Duration) DedupeStore {
+	return &memoryDedupe{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		ids:   make(map[string]*list.Element),
+	}
+}
+
+func (s *memoryDedupe) Seen(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.ids[id]
+	if !ok {
+		return false, nil
+	}
+
+	if time.Now().After(e.Value.(dedupeEntry).expires) {
+		s.order.Remove(e)
+		delete(s.ids, id)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *memoryDedupe) Mark(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := dedupeEntry{id: id, expires: time.Now().Add(s.ttl)}
+
+	if e, ok := s.ids[id]; ok {
+		e.Value = entry
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.ids[id] = s.order.PushFront(entry)
+
+	for s.order.Len() > s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.ids, oldest.Value.(dedupeEntry).id)
+	}
+
+	return nil
+}
+
+// redisDedupe keeps the message IDs in Redis, or any server that speaks its
+// protocol, for ttl. It is shared by every instance of the listener.
+type redisDedupe struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisDedupe returns a dedupe store that keeps the message IDs in the
+// Redis server at addr for ttl.
+func NewRedisDedupe(addr string, ttl time.Duration) DedupeStore {
+	return &redisDedupe{
+		client: redis.NewClient(&redis.Options{Addr: addr}),
+		ttl:    ttl,
+	}
+}
+
+func (s *redisDedupe) Seen(ctx context.Context, id string) (bool, error) {
+	n, err := s.client.Exists(ctx, dedupeKey(id)).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *redisDedupe) Mark(ctx context.Context, id string) error {
+	return s.client.Set(ctx, dedupeKey(id), 1, s.ttl).Err()
+}
+
+func dedupeKey(id string) string {
+	return "listener:handled:" + id
+}
@@ -0,0 +1,122 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestMemoryDedupe(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		size   int
+		ttl    time.Duration
+		marked []string
+		wait   time.Duration
+		seen   map[string]bool
+	}{
+		{
+			name:   "marked IDs are seen",
+			size:   10,
+			ttl:    time.Hour,
+			marked: []string{"a", "b"},
+			seen:   map[string]bool{"a": true, "b": true, "c": false},
+		},
+		{
+			name:   "the oldest IDs are forgotten past the size",
+			size:   2,
+			ttl:    time.Hour,
+			marked: []string{"a", "b", "c"},
+			seen:   map[string]bool{"a": false, "b": true, "c": true},
+		},
+		{
+			name:   "marking an ID again makes it the newest",
+			size:   2,
+			ttl:    time.Hour,
+			marked: []string{"a", "b", "a", "c"},
+			seen:   map[string]bool{"a": true, "b": false, "c": true},
+		},
+		{
+			name:   "IDs are forgotten after the ttl",
+			size:   10,
+			ttl:    10 * time.Millisecond,
+			marked: []string{"a"},
+			wait:   20 * time.Millisecond,
+			seen:   map[string]bool{"a": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryDedupe(tt.size, tt.ttl)
+
+			for _, id := range tt.marked {
+				err := store.Mark(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			time.Sleep(tt.wait)
+
+			for id, want := range tt.seen {
+				seen, err := store.Seen(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if seen != want {
+					t.Errorf("Seen(%q) = %v, want %v", id, seen, want)
+				}
+			}
+		})
+	}
+}
+
+// failingDedupe is a dedupe store that can't be reached
+type failingDedupe struct{}
+
+func (failingDedupe) Seen(ctx context.Context, id string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingDedupe) Mark(ctx context.Context, id string) error {
+	return errors.New("connection refused")
+}
+
+func TestConsumerHandled(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		dedupe DedupeStore
+		mark   bool
+		id     string
+		want   bool
+	}{
+		{name: "handled message", dedupe: NewMemoryDedupe(10, time.Hour), mark: true, id: "a", want: true},
+		{name: "new message", dedupe: NewMemoryDedupe(10, time.Hour), id: "a", want: false},
+		{name: "message without an ID", dedupe: NewMemoryDedupe(10, time.Hour), mark: true, id: "", want: false},
+		{name: "without a store", dedupe: nil, mark: true, id: "a", want: false},
+		{name: "store unreachable", dedupe: failingDedupe{}, mark: true, id: "a", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			consumer := &Consumer{dedupe: tt.dedupe}
+			d := amqp.Delivery{MessageId: tt.id}
+
+			if tt.mark {
+				consumer.markHandled(ctx, d)
+			}
+
+			if got := consumer.handled(ctx, d); got != tt.want {
+				t.Errorf("handled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
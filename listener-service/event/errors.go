@@ -0,0 +1,42 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// transientError is a failure of a handler that may not happen again, like a
+// service that can't be reached or that answers with a server error.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+func transient(err error) error {
+	return &transientError{err: err}
+}
+
+// isTransient reports whether err is worth retrying
+func isTransient(err error) bool {
+	var transientErr *transientError
+	return errors.As(err, &transientErr)
+}
+
+// statusError is the error of a service that didn't accept a request. Server
+// errors are transient, client errors are not.
+func statusError(response *http.Response) error {
+	err := fmt.Errorf("%s responded %s", response.Request.URL.Host, response.Status)
+	if response.StatusCode >= http.StatusInternalServerError {
+		return transient(err)
+	}
+
+	return err
+}
@@ -4,6 +4,9 @@ import (
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
+// deadLetterExchange receives the messages the queues give up on
+const deadLetterExchange = "go-micro.events.dlx"
+
 func declareExchange(ch *amqp.Channel) error {
 	return ch.ExchangeDeclare(
 		"go-micro.events.tx", // name
@@ -16,13 +19,55 @@ func declareExchange(ch *amqp.Channel) error {
 	)
 }
 
-func declareRandomQueue(ch *amqp.Channel) (amqp.Queue, error) {
+// declareQueue declares the durable quorum queue name, which dead-letters the
+// messages that were requeued maxDeliveries-1 times to name.dead
+func declareQueue(ch *amqp.Channel, name string, maxDeliveries int) (amqp.Queue, error) {
 	return ch.QueueDeclare(
-		"",    // name?
-		false, // durable?
+		name,  // name?
+		true,  // durable?
 		false, // delete when unused?
-		true,  // exclusive?
+		false, // exclusive?
 		false, // no-wait?
-		nil,   // arguments?
+		amqp.Table{ // arguments?
+			"x-queue-type":              "quorum",
+			"x-delivery-limit":          int32(maxDeliveries - 1),
+			"x-dead-letter-exchange":    deadLetterExchange,
+			"x-dead-letter-routing-key": deadLetterQueue(name),
+		},
 	)
 }
+
+// declareDeadLetterQueue declares the dead-letter exchange and the queue that
+// keeps the dead letters of the queue name
+func declareDeadLetterQueue(ch *amqp.Channel, name string) error {
+	err := ch.ExchangeDeclare(
+		deadLetterExchange, // name
+		"direct",           // type
+		true,               // durable?
+		false,              // auto-deleted?
+		false,              // internal?
+		false,              // no-wait?
+		nil,                // arguements?
+	)
+	if err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(
+		deadLetterQueue(name), // name?
+		true,                  // durable?
+		false,                 // delete when unused?
+		false,                 // exclusive?
+		false,                 // no-wait?
+		nil,                   // arguments?
+	)
+	if err != nil {
+		return err
+	}
+
+	return ch.QueueBind(q.Name, q.Name, deadLetterExchange, false, nil)
+}
+
+func deadLetterQueue(name string) string {
+	return name + ".dead"
+}
@@ -0,0 +1,223 @@
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Handler handles the events published with the routing keys it binds, by
+// decoding their data into its payload type and posting it to its target.
+type Handler struct {
+	// Name labels the metrics and spans of the handler
+	Name string
+	// Keys are the routing keys the queue is bound with for the handler. They
+	// may use the AMQP wildcards: * matches one word and # zero or more words.
+	Keys []string
+	// Payload returns a new value of the type the data of an event is decoded
+	// into. Payloads that implement Validator are validated once decoded, and
+	// the address of payloads that implement Forwarder is passed on to the target.
+	Payload func() any
+	// Target is the URL the payload is posted to as JSON. Handlers without a
+	// target reject every event they are given.
+	Target string
+}
+
+// RejectHandler returns the fallback handler of the events no other handler
+// matches, which are dead-lettered with their unknown routing key as the
+// reason instead of being posted to a service they weren't meant for.
+func RejectHandler() Handler {
+	return Handler{Name: "unmatched"}
+}
+
+// Forwarder is implemented by the payloads of events emitted for a client,
+// whose address is sent to the target in the X-Forwarded-For header.
+type Forwarder interface {
+	ForwardedFor() string
+}
+
+// handle decodes data into the payload of the handler, validates it and posts
+// it to the target, returning the response of the target as the reply
+func (h Handler) handle(ctx context.Context, routingKey string, data []byte, requestID string) (*Reply, error) {
+	if h.Target == "" {
+		return nil, invalid(fmt.Errorf("no handler for routing key %s", routingKey))
+	}
+
+	payload := h.Payload()
+
+	err := json.Unmarshal(data, payload)
+	if err != nil {
+		return nil, invalid(fmt.Errorf("decoding %s payload: %w", h.Name, err))
+	}
+
+	if v, ok := payload.(Validator); ok {
+		err = v.Validate()
+		if err != nil {
+			return nil, invalid(fmt.Errorf("invalid %s payload: %w", h.Name, err))
+		}
+	}
+
+	jsonData, _ := json.MarshalIndent(payload, "", "\t")
+
+	request, err := http.NewRequestWithContext(ctx, "POST", h.Target, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(requestIDHeader, requestID)
+	if f, ok := payload.(Forwarder); ok && f.ForwardedFor() != "" {
+		request.Header.Set("X-Forwarded-For", f.ForwardedFor())
+	}
+
+	client := &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, transient(err)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, transient(err)
+	}
+
+	// the response of the target is passed on as it is
+	reply := &Reply{
+		Status: response.StatusCode,
+		Body:   body,
+	}
+
+	// make sure we get back the right status code
+	if response.StatusCode != http.StatusAccepted {
+		return reply, statusError(response)
+	}
+
+	return reply, nil
+}
+
+// Registry routes events to the handler bound with the most specific matching
+// routing key, or to its fallback handler when none matches.
+type Registry struct {
+	handlers []Handler
+	fallback Handler
+}
+
+// NewRegistry returns a registry of handlers, which sends unmatched events to fallback.
+func NewRegistry(fallback Handler, handlers ...Handler) *Registry {
+	return &Registry{
+		handlers: handlers,
+		fallback: fallback,
+	}
+}
+
+// Register adds a handler to the registry.
+func (r *Registry) Register(h Handler) {
+	r.handlers = append(r.handlers, h)
+}
+
+// Keys returns the routing keys of every handler, to bind the queue with.
+func (r *Registry) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+
+	for _, h := range append([]Handler{r.fallback}, r.handlers...) {
+		for _, key := range h.Keys {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
+// Match returns the handler of routingKey. When several handlers match, the
+// one whose key has the most literal words wins, then the one with the fewest
+// # wildcards; ties go to the handler registered first.
+func (r *Registry) Match(routingKey string) Handler {
+	match := r.fallback
+	best := specificity{literals: -1}
+
+	for _, h := range r.handlers {
+		for _, key := range h.Keys {
+			if !matchKey(key, routingKey) {
+				continue
+			}
+
+			s := specificityOf(key)
+			if s.moreThan(best) {
+				match = h
+				best = s
+			}
+		}
+	}
+
+	return match
+}
+
+// specificity ranks the binding keys that match a routing key
+type specificity struct {
+	literals int
+	hashes   int
+}
+
+func specificityOf(key string) specificity {
+	var s specificity
+	for _, word := range strings.Split(key, ".") {
+		switch word {
+		case "#":
+			s.hashes++
+		case "*":
+		default:
+			s.literals++
+		}
+	}
+
+	return s
+}
+
+func (s specificity) moreThan(other specificity) bool {
+	if s.literals != other.literals {
+		return s.literals > other.literals
+	}
+
+	return s.hashes < other.hashes
+}
+
+// matchKey reports whether the binding key, with AMQP topic wildcards, matches routingKey
+func matchKey(key, routingKey string) bool {
+	return matchWords(strings.Split(key, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		// # swallows zero or more words
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,167 @@
+package event
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchKey(t *testing.T) {
+	tests := []struct {
+		key        string
+		routingKey string
+		want       bool
+	}{
+		{key: "log.INFO", routingKey: "log.INFO", want: true},
+		{key: "log.INFO", routingKey: "log.ERROR", want: false},
+		{key: "log.*", routingKey: "log.INFO", want: true},
+		{key: "log.*", routingKey: "log", want: false},
+		{key: "log.*", routingKey: "log.INFO.extra", want: false},
+		{key: "*.INFO", routingKey: "log.INFO", want: true},
+		{key: "log.#", routingKey: "log", want: true},
+		{key: "log.#", routingKey: "log.INFO.extra", want: true},
+		{key: "#", routingKey: "mail.SEND", want: true},
+		{key: "#.SEND", routingKey: "mail.SEND", want: true},
+		{key: "#.SEND", routingKey: "mail.queue.SEND", want: true},
+		{key: "#.SEND", routingKey: "mail.SEND.later", want: false},
+		{key: "mail.#.SEND", routingKey: "mail.SEND", want: true},
+		{key: "*.*", routingKey: "log", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchKey(tt.key, tt.routingKey); got != tt.want {
+			t.Errorf("matchKey(%q, %q) = %v, want %v", tt.key, tt.routingKey, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryMatch(t *testing.T) {
+	registry := NewRegistry(
+		Handler{Name: "fallback", Keys: []string{"log.*"}},
+		Handler{Name: "any", Keys: []string{"#"}},
+		Handler{Name: "mail", Keys: []string{"mail.*"}},
+		Handler{Name: "mail-deep", Keys: []string{"mail.#"}},
+		Handler{Name: "mail-send", Keys: []string{"mail.SEND"}},
+		Handler{Name: "auth", Keys: []string{"auth.*", "auth.CHECK"}},
+		Handler{Name: "auth-again", Keys: []string{"auth.CHECK"}},
+		Handler{Name: "audit", Keys: []string{"*.LOCKED"}},
+	)
+
+	tests := []struct {
+		routingKey string
+		want       string
+	}{
+		// a literal key beats the wildcards
+		{routingKey: "mail.SEND", want: "mail-send"},
+		// * beats # with as many literal words
+		{routingKey: "mail.QUEUE", want: "mail"},
+		{routingKey: "mail.QUEUE.later", want: "mail-deep"},
+		// the most specific key of a handler counts, and ties go to the first handler
+		{routingKey: "auth.CHECK", want: "auth"},
+		// ties on the literal words go to the first handler
+		{routingKey: "auth.LOCKED", want: "auth"},
+		{routingKey: "user.LOCKED", want: "audit"},
+		{routingKey: "log.INFO", want: "any"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.routingKey, func(t *testing.T) {
+			if got := registry.Match(tt.routingKey).Name; got != tt.want {
+				t.Errorf("Match(%q) = %s, want %s", tt.routingKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryMatchFallback(t *testing.T) {
+	registry := NewRegistry(Handler{Name: "log", Keys: []string{"log.*"}})
+	registry.Register(Handler{Name: "mail", Keys: []string{"mail.*"}})
+
+	for _, routingKey := range []string{"log.INFO", "auth.CHECK", "mail"} {
+		if got := registry.Match(routingKey).Name; got != "log" {
+			t.Errorf("Match(%q) = %s, want the fallback", routingKey, got)
+		}
+	}
+}
+
+func TestRegistryKeys(t *testing.T) {
+	registry := NewRegistry(
+		Handler{Name: "log", Keys: []string{"log.*", "auth.LOCKED"}},
+		Handler{Name: "auth", Keys: []string{"auth.CHECK", "auth.LOCKED"}},
+	)
+	registry.Register(Handler{Name: "mail", Keys: []string{"mail.*", "log.*"}})
+
+	want := []string{"log.*", "auth.LOCKED", "auth.CHECK", "mail.*"}
+	if got := registry.Keys(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestHandlerForwardsClientAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "with a client address", data: `{"email":"admin@example.com","password":"secret","client_address":"203.0.113.7"}`, want: "203.0.113.7"},
+		{name: "without a client address", data: `{"email":"admin@example.com","password":"secret"}`, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwardedFor string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				forwardedFor = r.Header.Get("X-Forwarded-For")
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+
+			h := Handler{
+				Name:    "auth",
+				Keys:    []string{"auth.CHECK"},
+				Payload: func() any { return &AuthPayload{} },
+				Target:  server.URL,
+			}
+
+			_, err := h.handle(context.Background(), "auth.CHECK", []byte(tt.data), "request-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if forwardedFor != tt.want {
+				t.Errorf("X-Forwarded-For = %q, want %q", forwardedFor, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectHandler(t *testing.T) {
+	registry := NewRegistry(RejectHandler(), Handler{
+		Name:    "log",
+		Keys:    []string{"log.*"},
+		Payload: func() any { return &LogPayload{} },
+		Target:  "http://logger-service/log",
+	})
+
+	h := registry.Match("audit.WRITE")
+	if h.Name != "unmatched" {
+		t.Fatalf("Match(audit.WRITE) = %s, want the reject handler", h.Name)
+	}
+
+	reply, err := h.handle(context.Background(), "audit.WRITE", []byte(`{"name":"event","data":"data"}`), "request-1")
+	if reply != nil {
+		t.Errorf("got reply %+v, want none", reply)
+	}
+	if !isInvalid(err) {
+		t.Fatalf("err = %v, want an invalid event", err)
+	}
+	if !strings.Contains(err.Error(), "audit.WRITE") {
+		t.Errorf("err = %v, want the routing key in the reason", err)
+	}
+
+	if keys := registry.Keys(); !reflect.DeepEqual(keys, []string{"log.*"}) {
+		t.Errorf("Keys() = %v, want only the keys of the handlers", keys)
+	}
+}
@@ -0,0 +1,53 @@
+package event
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Statuses of a job, published as the event it tracks is handled.
+const (
+	JobProcessing = "processing"
+	JobSucceeded  = "succeeded"
+	JobFailed     = "failed"
+)
+
+const (
+	// jobStatusKey is the routing key of the status events
+	jobStatusKey = "job.STATUS"
+	// jobIDAMQPHeader carries the ID of the job of an event
+	jobIDAMQPHeader = "x-job-id"
+)
+
+// JobEvent is the status of a job, sent back to the broker that started it.
+type JobEvent struct {
+	JobID  string `json:"job_id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// publishJobStatus publishes the status of a job to the exchange, as a
+// structured CloudEvent. The reason of a failed job is the error of its handler.
+func publishJobStatus(ch *amqp.Channel, jobID, requestID, status string, err error) {
+	evt := JobEvent{
+		JobID:  jobID,
+		Status: status,
+	}
+
+	if err != nil {
+		evt.Reason = err.Error()
+	}
+
+	data, _ := json.Marshal(evt)
+	msg := NewCloudEvent(newEventID(), jobStatusKey, data, time.Now()).publishing(Structured, amqp.Table{
+		requestIDAMQPHeader: requestID,
+	})
+
+	err = ch.Publish("go-micro.events.tx", jobStatusKey, false, false, msg)
+	if err != nil {
+		log.Println("Error publishing status of job", jobID, err)
+	}
+}
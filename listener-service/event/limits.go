@@ -0,0 +1,43 @@
+package event
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// topicLimits bounds the number of messages of each topic handled at once.
+// Topics without a limit are only bounded by the number of workers.
+type topicLimits map[string]chan struct{}
+
+func newTopicLimits(limits map[string]int) topicLimits {
+	l := make(topicLimits)
+	for topic, limit := range limits {
+		if limit > 0 {
+			l[topic] = make(chan struct{}, limit)
+		}
+	}
+
+	return l
+}
+
+// acquire waits for a slot of topic and returns the function that frees it
+func (l topicLimits) acquire(topic string) func() {
+	slots, ok := l[topic]
+	if !ok {
+		return func() {}
+	}
+
+	slots <- struct{}{}
+
+	return func() {
+		<-slots
+	}
+}
+
+// consumerTag returns a tag unique to this consumer of queue, so it can be cancelled
+func consumerTag(queue string) string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+
+	return queue + "-" + hex.EncodeToString(b[:])
+}
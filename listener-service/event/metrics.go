@@ -0,0 +1,70 @@
+package event
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	consumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "listener",
+		Name:      "rabbitmq_consumed_total",
+		Help:      "Messages consumed from RabbitMQ, by routing key.",
+	}, []string{"routing_key"})
+
+	handledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "listener",
+		Name:      "handled_total",
+		Help:      "Events handled, by handler and result (ok or error).",
+	}, []string{"handler", "result"})
+
+	handleDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "listener",
+		Name:      "handle_duration_seconds",
+		Help:      "Time taken to handle an event, by handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler"})
+
+	dedupedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "listener",
+		Name:      "deduplicated_total",
+		Help:      "Messages acked without handling them, as their ID was already handled, by routing key.",
+	}, []string{"routing_key"})
+
+	rejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "listener",
+		Name:      "rejected_total",
+		Help:      "Messages dead-lettered as they can't be decoded or their payload is invalid, by routing key.",
+	}, []string{"routing_key"})
+
+	inFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "listener",
+		Name:      "in_flight",
+		Help:      "Messages being handled by the workers.",
+	})
+
+	rabbitConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "listener",
+		Name:      "rabbitmq_connected",
+		Help:      "Whether the connection to RabbitMQ is up (1) or being dialed again (0).",
+	})
+
+	rabbitReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "listener",
+		Name:      "rabbitmq_reconnects_total",
+		Help:      "Connections to RabbitMQ dialed again after the previous one closed.",
+	})
+)
+
+// observeHandler records an event handled by handler that took since start and ended with err
+func observeHandler(handler string, start time.Time, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	handledTotal.WithLabelValues(handler, result).Inc()
+	handleDuration.WithLabelValues(handler).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,77 @@
+package event
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Parked returns the name of the parking queue and the number of messages in it.
+func (consumer *Consumer) Parked() (string, int, error) {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return "", 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(parkingQueue(consumer.queueName), true, false, false, false, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return q.Name, q.Messages, nil
+}
+
+// Replay moves up to limit messages from the parking queue back to the queue,
+// with their attempt counter reset, and returns the number of messages moved.
+func (consumer *Consumer) Replay(limit int) (int, error) {
+	ch, err := consumer.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	replayed := 0
+
+	for replayed < limit {
+		d, ok, err := ch.Get(parkingQueue(consumer.queueName), false)
+		if err != nil {
+			return replayed, err
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp.Table{}
+		for key, value := range d.Headers {
+			headers[key] = value
+		}
+		delete(headers, attemptAMQPHeader)
+
+		err = ch.Publish(
+			"",
+			consumer.queueName,
+			false,
+			false,
+			amqp.Publishing{
+				ContentType:   d.ContentType,
+				DeliveryMode:  amqp.Persistent,
+				CorrelationId: d.CorrelationId,
+				MessageId:     d.MessageId,
+				Headers:       headers,
+				Body:          d.Body,
+			},
+		)
+		if err != nil {
+			_ = d.Nack(false, true)
+			return replayed, err
+		}
+
+		err = d.Ack(false)
+		if err != nil {
+			return replayed, err
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
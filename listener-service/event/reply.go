@@ -0,0 +1,50 @@
+package event
+
+import (
+	"encoding/json"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// statusAMQPHeader carries the status code of a reply.
+const statusAMQPHeader = "x-status"
+
+// Reply is the result of an event, sent back to publishers that wait for one
+// (the ones that set ReplyTo). It carries the status code and JSON body answered
+// by the service that handled the event.
+type Reply struct {
+	Status int
+	Body   []byte
+}
+
+// newReply returns a reply with a JSON body like the ones of the services
+func newReply(status int, failed bool, message string) Reply {
+	body, _ := json.Marshal(struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{failed, message})
+
+	return Reply{
+		Status: status,
+		Body:   body,
+	}
+}
+
+// sendReply publishes reply to the queue named in the ReplyTo of d, with its
+// correlation ID, through the default exchange
+func sendReply(ch *amqp.Channel, d amqp.Delivery, reply Reply) error {
+	return ch.Publish(
+		"",
+		d.ReplyTo,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: d.CorrelationId,
+			Headers: amqp.Table{
+				statusAMQPHeader: int32(reply.Status),
+			},
+			Body: reply.Body,
+		},
+	)
+}
@@ -0,0 +1,129 @@
+package event
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// attemptAMQPHeader counts the failed attempts to handle a message
+	attemptAMQPHeader = "x-attempt"
+	// routingKeyAMQPHeader keeps the routing key a retried message was published with
+	routingKeyAMQPHeader = "x-routing-key"
+)
+
+// DefaultRetryDelays are the delays before each retry of a message that failed
+// for a transient reason.
+var DefaultRetryDelays = []time.Duration{5 * time.Second, 30 * time.Second, 5 * time.Minute}
+
+// retryQueue is the name of the delay queue of the queue name whose messages
+// wait for delay
+func retryQueue(name string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", name, delay)
+}
+
+// parkingQueue is the name of the queue that keeps the messages of the queue
+// name that failed every retry
+func parkingQueue(name string) string {
+	return name + ".parking"
+}
+
+// declareRetryQueues declares a delay queue per delay, whose messages expire
+// after delay and are then dead-lettered back to the queue name, and the
+// parking queue of name.
+func declareRetryQueues(ch *amqp.Channel, name string, delays []time.Duration) error {
+	for _, delay := range delays {
+		_, err := ch.QueueDeclare(
+			retryQueue(name, delay), // name?
+			true,                    // durable?
+			false,                   // delete when unused?
+			false,                   // exclusive?
+			false,                   // no-wait?
+			amqp.Table{ // arguments?
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": name,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := ch.QueueDeclare(
+		parkingQueue(name), // name?
+		true,               // durable?
+		false,              // delete when unused?
+		false,              // exclusive?
+		false,              // no-wait?
+		nil,                // arguments?
+	)
+
+	return err
+}
+
+// retry republishes a message that failed for a transient reason to the delay
+// queue of its next attempt, or to the parking queue once every delay was
+// tried, and acks it. It reports whether the message was parked. Messages
+// that can't be republished are requeued.
+func (consumer *Consumer) retry(ch *amqp.Channel, d amqp.Delivery, cause error) (bool, error) {
+	attempt := attempts(d) + 1
+
+	queue := parkingQueue(consumer.queueName)
+	parked := attempt > len(consumer.retryDelays)
+	if !parked {
+		queue = retryQueue(consumer.queueName, consumer.retryDelays[attempt-1])
+	}
+
+	headers := amqp.Table{}
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[attemptAMQPHeader] = int32(attempt)
+	headers[routingKeyAMQPHeader] = routingKey(d)
+
+	err := ch.Publish(
+		"",
+		queue,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   d.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			CorrelationId: d.CorrelationId,
+			MessageId:     d.MessageId,
+			Headers:       headers,
+			Body:          d.Body,
+		},
+	)
+	if err != nil {
+		_ = d.Nack(false, true)
+		return false, err
+	}
+
+	if parked {
+		log.Printf("Parking message after %d attempts: %v\n", attempt, cause)
+	} else {
+		log.Printf("Retrying message in %s (attempt %d): %v\n", consumer.retryDelays[attempt-1], attempt, cause)
+	}
+
+	return parked, d.Ack(false)
+}
+
+// attempts returns the number of failed attempts to handle d
+func attempts(d amqp.Delivery) int {
+	attempt, _ := d.Headers[attemptAMQPHeader].(int32)
+	return int(attempt)
+}
+
+// routingKey returns the routing key d was first published with
+func routingKey(d amqp.Delivery) string {
+	if key, ok := d.Headers[routingKeyAMQPHeader].(string); ok {
+		return key
+	}
+
+	return d.RoutingKey
+}
@@ -0,0 +1,135 @@
+package event
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// rejectedReasonAMQPHeader carries the reason a message was dead-lettered for
+const rejectedReasonAMQPHeader = "x-rejected-reason"
+
+// Validator is implemented by the payloads that check their fields once decoded.
+type Validator interface {
+	Validate() error
+}
+
+// invalidError is an event that can't be handled as it is, like data that
+// doesn't decode into the payload of its handler or fails its validation.
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidError) Unwrap() error {
+	return e.err
+}
+
+func invalid(err error) error {
+	return &invalidError{err: err}
+}
+
+// isInvalid reports whether err is an event that will never be handled
+func isInvalid(err error) bool {
+	var invalidErr *invalidError
+	return errors.As(err, &invalidErr)
+}
+
+// fieldErrors collects the fields of a payload that failed validation
+type fieldErrors []string
+
+func (f *fieldErrors) require(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		*f = append(*f, field+" is required")
+	}
+}
+
+func (f *fieldErrors) email(field, value string) {
+	if value == "" {
+		return
+	}
+
+	if _, err := mail.ParseAddress(value); err != nil {
+		*f = append(*f, field+" is not a valid email address")
+	}
+}
+
+func (f fieldErrors) err() error {
+	if len(f) == 0 {
+		return nil
+	}
+
+	return errors.New(strings.Join(f, ", "))
+}
+
+func (p *LogPayload) Validate() error {
+	var f fieldErrors
+	f.require("name", p.Name)
+
+	return f.err()
+}
+
+func (p *MailPayload) Validate() error {
+	var f fieldErrors
+	// the mailer sends from its own address when from is empty
+	f.email("from", p.From)
+	f.require("to", p.To)
+	f.email("to", p.To)
+	f.require("subject", p.Subject)
+	f.require("message", p.Message)
+
+	return f.err()
+}
+
+func (p *AuthPayload) Validate() error {
+	var f fieldErrors
+	f.require("email", p.Email)
+	f.email("email", p.Email)
+	f.require("password", p.Password)
+
+	return f.err()
+}
+
+// reject dead-letters an invalid message to the dead-letter queue of the
+// consumer, with the reason in the x-rejected-reason header, and acks it.
+// Messages that can't be republished are dead-lettered by RabbitMQ, without
+// a reason.
+func (consumer *Consumer) reject(ch *amqp.Channel, d amqp.Delivery, cause error) error {
+	log.Println("Rejecting invalid message:", cause)
+	rejectedTotal.WithLabelValues(routingKey(d)).Inc()
+
+	headers := amqp.Table{}
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[rejectedReasonAMQPHeader] = cause.Error()
+	headers[routingKeyAMQPHeader] = routingKey(d)
+
+	err := ch.Publish(
+		deadLetterExchange,
+		deadLetterQueue(consumer.queueName),
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   d.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			CorrelationId: d.CorrelationId,
+			MessageId:     d.MessageId,
+			Headers:       headers,
+			Body:          d.Body,
+		},
+	)
+	if err != nil {
+		_ = d.Nack(false, false)
+		return fmt.Errorf("dead-lettering message: %w", err)
+	}
+
+	return d.Ack(false)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"listener-service/event"
+	"net/http"
+	"strconv"
+)
+
+// parked answers GET /parking with the number of messages in the parking queue
+func parked(consumer *event.Consumer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		queue, messages, err := consumer.Parked()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"queue":    queue,
+			"messages": messages,
+		})
+	})
+}
+
+// replay answers POST /parking/replay by moving the parked messages back to the
+// queue of the listener, at most ?limit=n of them (all of them by default)
+func replay(consumer *event.Consumer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		_, limit, err := consumer.Parked()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		if value := r.URL.Query().Get("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+		}
+
+		replayed, err := consumer.Replay(limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"replayed": replayed,
+		})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
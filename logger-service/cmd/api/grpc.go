@@ -8,10 +8,10 @@ import (
 	"logger-service/logs"
 	"net"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
-
 type LogServer struct {
 	logs.UnimplementedLogServiceServer
 	Models data.Models
@@ -21,17 +21,20 @@ func (l *LogServer) WriteLog(ctx context.Context, req *logs.LogRequest) (*logs.L
 	input := req.GetLogEntry()
 
 	// write the log
-	logEntry := data.LogEntry {
-		Name: input.Name,
-		Data: input.Data,
+	logEntry := data.LogEntry{
+		Name:      input.Name,
+		Data:      input.Data,
+		RequestID: requestIDFromMetadata(ctx),
 	}
 
-	err := l.Models.LogEntry.Insert(logEntry)
+	err := l.Models.LogEntry.Insert(ctx, logEntry)
 	if err != nil {
 		res := &logs.LogResponse{Result: "failed"}
 		return res, err
 	}
 
+	entriesTotal.WithLabelValues("grpc").Inc()
+
 	// return response
 	res := &logs.LogResponse{Result: "logged!"}
 	return res, nil
@@ -43,7 +46,7 @@ func (app *Config) gRPCListen() {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), metricsInterceptor))
 
 	logs.RegisterLogServiceServer(s, &LogServer{Models: app.Models})
 
@@ -52,4 +55,4 @@ func (app *Config) gRPCListen() {
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
-}
\ No newline at end of file
+}
@@ -17,16 +17,19 @@ func (app *Config) WriteLog(w http.ResponseWriter, r *http.Request) {
 
 	// insert data
 	event := data.LogEntry{
-		Name: requestPayload.Name,
-		Data: requestPayload.Data,
+		Name:      requestPayload.Name,
+		Data:      requestPayload.Data,
+		RequestID: r.Header.Get(requestIDHeader),
 	}
 
-	err := app.Models.LogEntry.Insert(event)
+	err := app.Models.LogEntry.Insert(r.Context(), event)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
+	entriesTotal.WithLabelValues("http").Inc()
+
 	resp := jsonResponse{
 		Error:   false,
 		Message: "logged via JSON",
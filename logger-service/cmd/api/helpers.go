@@ -1,10 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// The correlation ID of a request is received in this header or gRPC metadata key.
+const (
+	requestIDHeader   = "X-Request-ID"
+	requestIDMetadata = "x-request-id"
 )
 
 type jsonResponse struct {
@@ -71,3 +80,18 @@ func (app *Config) errorJSON(w http.ResponseWriter, err error, status ...int) er
 
 	return app.writeJSON(w, statusCode, payload)
 }
+
+// requestIDFromMetadata returns the correlation ID sent by a gRPC caller
+func requestIDFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(requestIDMetadata)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
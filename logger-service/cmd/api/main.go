@@ -12,6 +12,7 @@ import (
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 const (
@@ -28,6 +29,13 @@ type Config struct {
 }
 
 func main() {
+	// set up tracing
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// connect to mongo
 	mongoClient, err := connectToMongo()
 	if err != nil {
@@ -90,6 +98,7 @@ func (app *Config) rpcListen() error {
 func connectToMongo() (*mongo.Client, error) {
 	// create connection options
 	clientOptions := options.Client().ApplyURI(mongoURL)
+	clientOptions.SetMonitor(commandMonitor(otelmongo.NewMonitor()))
 	clientOptions.SetAuth(options.Credential{
 		Username: "admin",
 		Password: "password",
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Name:      "requests_total",
+		Help:      "Requests handled, by transport, method and status (HTTP status code, gRPC code, or ok/error for net/rpc).",
+	}, []string{"transport", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "logger",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle a request, by transport and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"transport", "method"})
+
+	entriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "logger",
+		Name:      "entries_total",
+		Help:      "Log entries written, by transport.",
+	}, []string{"transport"})
+
+	commandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "logger",
+		Name:      "db_command_duration_seconds",
+		Help:      "Time taken by MongoDB commands, by command and result (ok or error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"command", "result"})
+)
+
+// observeRequest records a request to method over transport that took since start
+func observeRequest(transport, method, status string, start time.Time) {
+	requestsTotal.WithLabelValues(transport, method, status).Inc()
+	requestDuration.WithLabelValues(transport, method).Observe(time.Since(start).Seconds())
+}
+
+// metrics records the count and latency of HTTP requests by route
+func metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		method := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+		observeRequest("http", method, strconv.Itoa(ww.Status()), start)
+	})
+}
+
+// metricsInterceptor records the count and latency of gRPC calls by method
+func metricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+	observeRequest("grpc", info.FullMethod, status.Code(err).String(), start)
+
+	return resp, err
+}
+
+// observeRPC records a net/rpc call to method. It is deferred by the methods of
+// RPCServer with a pointer to their returned error.
+func observeRPC(method string, start time.Time, err *error) {
+	result := "ok"
+	if *err != nil {
+		result = "error"
+	}
+
+	observeRequest("rpc", method, result, start)
+}
+
+// commandMonitor records the duration of every MongoDB command, then passes the
+// events on to next
+func commandMonitor(next *event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: next.Started,
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			commandDuration.WithLabelValues(evt.CommandName, "ok").Observe(time.Duration(evt.DurationNanos).Seconds())
+			next.Succeeded(ctx, evt)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			commandDuration.WithLabelValues(evt.CommandName, "error").Observe(time.Duration(evt.DurationNanos).Seconds())
+			next.Failed(ctx, evt)
+		},
+	}
+}
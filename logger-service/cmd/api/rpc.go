@@ -1,11 +1,12 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
 	"log"
 	"logger-service/data"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RPCServer is the type for our RPC Server. Methods that take this as a receiver are available
@@ -14,8 +15,10 @@ type RPCServer struct{}
 
 // RPCPayload is the type for data we receive from RPC
 type RPCPayload struct {
-	Name string
-	Data string
+	RequestID    string
+	TraceContext map[string]string
+	Name         string
+	Data         string
 }
 
 type RPCResponse struct {
@@ -25,11 +28,17 @@ type RPCResponse struct {
 }
 
 // LogInfo writes our payload to mongo
-func (r *RPCServer) LogInfo(payload RPCPayload, resp *[]byte) error {
+func (r *RPCServer) LogInfo(payload RPCPayload, resp *[]byte) (err error) {
+	defer observeRPC("RPCServer.LogInfo", time.Now(), &err)
+
+	ctx, span := tracer.Start(contextFromCarrier(payload.TraceContext), "RPCServer.LogInfo", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	collection := client.Database("logs").Collection("logs")
-	_, err := collection.InsertOne(context.TODO(), data.LogEntry{
+	_, err = collection.InsertOne(ctx, data.LogEntry{
 		Name:      payload.Name,
 		Data:      payload.Data,
+		RequestID: payload.RequestID,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	})
@@ -38,6 +47,8 @@ func (r *RPCServer) LogInfo(payload RPCPayload, resp *[]byte) error {
 		return err
 	}
 
+	entriesTotal.WithLabelValues("rpc").Inc()
+
 	// resp is the message sent back to the RPC caller
 	*resp, _ = json.Marshal(RPCResponse{
 		Error:   false,
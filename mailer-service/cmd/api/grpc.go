@@ -7,6 +7,7 @@ import (
 	"mailer-service/mails"
 	"net"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 )
 
@@ -18,13 +19,16 @@ func (m *MailServer) SendMail(ctx context.Context, req *mails.MailRequest) (*mai
 	input := req.GetMailEntry()
 
 	msg := Message{
-		From:    input.From,
-		To:      input.To,
-		Subject: input.Subject,
-		Data:    input.Message,
+		RequestID:    requestIDFromMetadata(ctx),
+		From:         input.From,
+		To:           input.To,
+		Subject:      input.Subject,
+		Data:         input.Message,
+		Template:     input.Template,
+		TemplateData: input.Data,
 	}
 
-	err := app.Mailer.SendSMTPMessage(msg)
+	err := app.Mailer.SendSMTPMessage(ctx, msg)
 	if err != nil {
 		res := &mails.MailResponse{Result: "failed"}
 		return res, err
@@ -41,7 +45,7 @@ func (app *Config) gRPCListen() {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
 
-	s := grpc.NewServer()
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), metricsInterceptor))
 
 	mails.RegisterMailServiceServer(s, &MailServer{})
 
@@ -50,4 +54,4 @@ func (app *Config) gRPCListen() {
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("Failed to listen for gRPC: %v", err)
 	}
-}
\ No newline at end of file
+}
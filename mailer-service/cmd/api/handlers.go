@@ -6,10 +6,12 @@ import (
 
 func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	type mailMessage struct {
-		From    string `json:"from"`
-		To      string `json:"to"`
-		Subject string `json:"subject"`
-		Message string `json:"message"`
+		From     string            `json:"from"`
+		To       string            `json:"to"`
+		Subject  string            `json:"subject"`
+		Message  string            `json:"message"`
+		Template string            `json:"template,omitempty"`
+		Data     map[string]string `json:"data,omitempty"`
 	}
 
 	var requestPayload mailMessage
@@ -21,13 +23,16 @@ func (app *Config) SendMail(w http.ResponseWriter, r *http.Request) {
 	}
 
 	msg := Message{
-		From:    requestPayload.From,
-		To:      requestPayload.To,
-		Subject: requestPayload.Subject,
-		Data:    requestPayload.Message,
+		RequestID:    r.Header.Get(requestIDHeader),
+		From:         requestPayload.From,
+		To:           requestPayload.To,
+		Subject:      requestPayload.Subject,
+		Data:         requestPayload.Message,
+		Template:     requestPayload.Template,
+		TemplateData: requestPayload.Data,
 	}
 
-	err = app.Mailer.SendSMTPMessage(msg)
+	err = app.Mailer.SendSMTPMessage(r.Context(), msg)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -2,11 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"html/template"
+	"log"
+	"regexp"
 	"time"
 
 	"github.com/vanng822/go-premailer/premailer"
 	mail "github.com/xhit/go-simple-mail/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Mail struct {
@@ -20,7 +26,14 @@ type Mail struct {
 	FromName    string
 }
 
+// defaultTemplate is the template of the messages that don't name one
+const defaultTemplate = "mail"
+
+// templateName is the pattern of the template names, which are part of paths
+var templateName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 type Message struct {
+	RequestID   string
 	From        string
 	FromName    string
 	To          string
@@ -28,9 +41,18 @@ type Message struct {
 	Attachments []string
 	Data        any
 	DataMap     map[string]any
+	// Template names the templates the message is rendered from, like
+	// password-reset for ./templates/password-reset.html.gohtml and
+	// ./templates/password-reset.plain.gohtml (defaultTemplate if empty)
+	Template string
+	// TemplateData is passed to the templates, along with Data as message
+	TemplateData map[string]string
 }
 
-func (m *Mail) SendSMTPMessage(msg Message) error {
+func (m *Mail) SendSMTPMessage(ctx context.Context, msg Message) error {
+	_, span := tracer.Start(ctx, "Mail.SendSMTPMessage", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
 	if msg.From == "" {
 		msg.From = m.FromAddress
 	}
@@ -39,9 +61,20 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 		msg.FromName = m.FromName
 	}
 
+	if msg.Template == "" {
+		msg.Template = defaultTemplate
+	}
+
+	if !templateName.MatchString(msg.Template) {
+		return fmt.Errorf("invalid template name %q", msg.Template)
+	}
+
 	data := map[string]any{
 		"message": msg.Data,
 	}
+	for key, value := range msg.TemplateData {
+		data[key] = value
+	}
 
 	msg.DataMap = data
 
@@ -67,6 +100,9 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 
 	smtpClient, err := server.Connect()
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "connect")
+		mailsTotal.WithLabelValues("failed").Inc()
 		return err
 	}
 
@@ -86,14 +122,22 @@ func (m *Mail) SendSMTPMessage(msg Message) error {
 
 	err = email.Send(smtpClient)
 	if err != nil {
+		log.Printf("Error sending mail to %s (request %s): %v\n", msg.To, msg.RequestID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "send")
+		mailsTotal.WithLabelValues("failed").Inc()
 		return err
 	}
 
+	mailsTotal.WithLabelValues("sent").Inc()
+
+	log.Printf("Mail sent to %s (request %s)\n", msg.To, msg.RequestID)
+
 	return nil
 }
 
 func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.html.gohtml"
+	templateToRender := fmt.Sprintf("./templates/%s.html.gohtml", msg.Template)
 
 	t, err := template.New("email-html").ParseFiles(templateToRender)
 	if err != nil {
@@ -115,7 +159,7 @@ func (m *Mail) buildHTMLMessage(msg Message) (string, error) {
 }
 
 func (m *Mail) buildPlainTextMessage(msg Message) (string, error) {
-	templateToRender := "./templates/mail.plain.gohtml"
+	templateToRender := fmt.Sprintf("./templates/%s.plain.gohtml", msg.Template)
 
 	t, err := template.New("email-plain").ParseFiles(templateToRender)
 	if err != nil {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -23,12 +24,19 @@ const (
 var app Config
 
 func main() {
+	// set up tracing
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Panic(err)
+	}
+	defer shutdownTracing(context.Background())
+
 	app = Config{
 		Mailer: createMail(),
 	}
 
 	// Register the RPC Server
-	err := rpc.Register(new(RPCServer))
+	err = rpc.Register(new(RPCServer))
 	if err != nil {
 		log.Panic(err)
 	}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailer",
+		Name:      "requests_total",
+		Help:      "Requests handled, by transport, method and status (HTTP status code, gRPC code, or ok/error for net/rpc).",
+	}, []string{"transport", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "mailer",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle a request, by transport and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"transport", "method"})
+
+	mailsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "mailer",
+		Name:      "mails_total",
+		Help:      "Mails handed to the SMTP server, by result (sent or failed).",
+	}, []string{"result"})
+)
+
+// observeRequest records a request to method over transport that took since start
+func observeRequest(transport, method, status string, start time.Time) {
+	requestsTotal.WithLabelValues(transport, method, status).Inc()
+	requestDuration.WithLabelValues(transport, method).Observe(time.Since(start).Seconds())
+}
+
+// metrics records the count and latency of HTTP requests by route
+func metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		method := r.Method + " " + chi.RouteContext(r.Context()).RoutePattern()
+		observeRequest("http", method, strconv.Itoa(ww.Status()), start)
+	})
+}
+
+// metricsInterceptor records the count and latency of gRPC calls by method
+func metricsInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+	observeRequest("grpc", info.FullMethod, status.Code(err).String(), start)
+
+	return resp, err
+}
+
+// observeRPC records a net/rpc call to method. It is deferred by the methods of
+// RPCServer with a pointer to their returned error.
+func observeRPC(method string, start time.Time, err *error) {
+	result := "ok"
+	if *err != nil {
+		result = "error"
+	}
+
+	observeRequest("rpc", method, result, start)
+}
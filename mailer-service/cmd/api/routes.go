@@ -6,6 +6,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func (app *Config) routes() http.Handler {
@@ -23,7 +25,16 @@ func (app *Config) routes() http.Handler {
 
 	mux.Use(middleware.Heartbeat("/ping"))
 
+	mux.Use(metrics)
+
 	mux.Post("/send", app.SendMail)
 
-	return mux
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return otelhttp.NewHandler(mux, serviceName, otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// spanName names the span of a request after its method and path
+func spanName(operation string, r *http.Request) string {
+	return r.Method + " " + r.URL.Path
 }
@@ -3,6 +3,9 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // RPCServer is the type for our RPC Server. Methods that take this as a receiver are available
@@ -11,11 +14,15 @@ type RPCServer struct{}
 
 // RPCPayload is the type for data we receive from RPC
 type RPCPayload struct {
-	Name    string
-	From    string
-	To      string
-	Subject string
-	Message string
+	RequestID    string
+	TraceContext map[string]string
+	Name         string
+	From         string
+	To           string
+	Subject      string
+	Message      string
+	Template     string
+	Data         map[string]string
 }
 
 type RPCResponse struct {
@@ -25,15 +32,23 @@ type RPCResponse struct {
 }
 
 // LogInfo writes our payload to mongo
-func (r *RPCServer) SendMailViaRPC(payload RPCPayload, resp *[]byte) error {
+func (r *RPCServer) SendMailViaRPC(payload RPCPayload, resp *[]byte) (err error) {
+	defer observeRPC("RPCServer.SendMailViaRPC", time.Now(), &err)
+
+	ctx, span := tracer.Start(contextFromCarrier(payload.TraceContext), "RPCServer.SendMailViaRPC", trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	msg := Message{
-		From:    payload.From,
-		To:      payload.To,
-		Subject: payload.Subject,
-		Data:    payload.Message,
+		RequestID:    payload.RequestID,
+		From:         payload.From,
+		To:           payload.To,
+		Subject:      payload.Subject,
+		Data:         payload.Message,
+		Template:     payload.Template,
+		TemplateData: payload.Data,
 	}
 
-	err := app.Mailer.SendSMTPMessage(msg)
+	err = app.Mailer.SendSMTPMessage(ctx, msg)
 	if err != nil {
 		log.Println("error sending mail", err)
 		return err